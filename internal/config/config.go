@@ -15,7 +15,11 @@
 
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+)
 
 type Config struct {
 	DBHost     string
@@ -24,9 +28,56 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	JWTSecret  string
+	JWTKeys    string // keyring spec, see middleware.NewKeyring; empty = JWTSecret only
+	JWTSignKey string // kid that signs new tokens; empty = first key
 	Port       string
 	UploadDir  string
 	MaxUpload  int64 // bytes
+	SchoolName string
+	ReportDir  string // scheduled report output
+
+	// BedtimeReportCron is when the nightly 晚休 summary of every building
+	// with users is generated; "off" stops creating these jobs.
+	BedtimeReportCron string
+
+	MetricsToken string // bearer token for /metrics; empty disables it
+	PublicMask   string // masking level on the public screen without a display
+	PublicOpen   bool   // serve the public feed without a display token
+
+	// Watch list: a student is flagged once they reach WatchCount
+	// violations or WatchPoints points (0 = off) within WatchDays days.
+	WatchCount  int
+	WatchPoints int
+	WatchDays   int
+
+	TermStarts []string // MM-DD each term begins on
+
+	// Login throttling: after LoginMaxFailures failed attempts for one
+	// username (LoginIPMaxFailures for one IP) within LoginWindow minutes,
+	// further attempts are refused for LoginLockBase minutes, doubling with
+	// every further failure up to LoginLockMax minutes.
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginWindow        int
+	LoginLockBase      int
+	LoginLockMax       int
+
+	// TrustedProxies lists the reverse proxies (IPs or CIDRs) whose
+	// X-Forwarded-For is believed when counting failed logins per IP.
+	TrustedProxies []string
+
+	// Access tokens live AccessTokenTTL minutes and are renewed with a
+	// refresh token that lives RefreshTokenTTL hours; each refresh issues a
+	// new refresh token and extends the session.
+	AccessTokenTTL  int
+	RefreshTokenTTL int
+
+	// Password policy: minimum length, how many of lower case, upper case,
+	// digits and symbols must appear, and how many previous passwords may
+	// not be reused.
+	PasswordMinLength int
+	PasswordClasses   int
+	PasswordHistory   int
 }
 
 func Load() *Config {
@@ -37,9 +88,39 @@ func Load() *Config {
 		DBPassword: getEnv("DB_PASSWORD", "suv_password"),
 		DBName:     getEnv("DB_NAME", "suv"),
 		JWTSecret:  getEnv("JWT_SECRET", "change-me-in-production-32chars!"),
+		JWTKeys:    getEnv("JWT_KEYS", ""),
+		JWTSignKey: getEnv("JWT_SIGNING_KEY", ""),
 		Port:       getEnv("PORT", "8080"),
 		UploadDir:  getEnv("UPLOAD_DIR", "./uploads"),
 		MaxUpload:  5 * 1024 * 1024, // 5MB
+		SchoolName: getEnv("SCHOOL_NAME", "学生会"),
+		ReportDir:  getEnv("REPORT_DIR", "./reports"),
+
+		BedtimeReportCron: getEnv("BEDTIME_REPORT_CRON", "30 22 * * *"),
+
+		MetricsToken: os.Getenv("METRICS_TOKEN"),
+		PublicMask:   getEnv("PUBLIC_MASK", "partial"),
+		PublicOpen:   getEnv("PUBLIC_OPEN", "true") == "true",
+
+		WatchCount:  getEnvInt("WATCH_COUNT", 3),
+		WatchPoints: getEnvInt("WATCH_POINTS", 0),
+		WatchDays:   getEnvInt("WATCH_DAYS", 30),
+
+		TermStarts: strings.Split(getEnv("TERM_STARTS", "02-01,09-01"), ","),
+
+		LoginMaxFailures:   getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: getEnvInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginWindow:        getEnvInt("LOGIN_WINDOW", 15),
+		LoginLockBase:      getEnvInt("LOGIN_LOCK_BASE", 1),
+		LoginLockMax:       getEnvInt("LOGIN_LOCK_MAX", 60),
+		TrustedProxies:     splitList(getEnv("TRUSTED_PROXIES", "")),
+
+		AccessTokenTTL:  getEnvInt("ACCESS_TOKEN_TTL", 15),
+		RefreshTokenTTL: getEnvInt("REFRESH_TOKEN_TTL", 168),
+
+		PasswordMinLength: getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordClasses:   getEnvInt("PASSWORD_CLASSES", 2),
+		PasswordHistory:   getEnvInt("PASSWORD_HISTORY", 3),
 	}
 }
 
@@ -49,3 +130,21 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// splitList reads a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
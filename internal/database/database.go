@@ -62,10 +62,32 @@ func Migrate(db *sql.DB) error {
 			username VARCHAR(50) NOT NULL UNIQUE,
 			password_hash VARCHAR(255) NOT NULL,
 			display_name VARCHAR(50) NOT NULL DEFAULT '',
-			role ENUM('admin','staff') NOT NULL DEFAULT 'staff',
+			role VARCHAR(30) NOT NULL DEFAULT 'staff',
+			department VARCHAR(30) NOT NULL DEFAULT '',
+			building VARCHAR(20) NOT NULL DEFAULT '',
+			must_change_password TINYINT(1) NOT NULL DEFAULT 0,
+			password_changed_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS roles (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(30) NOT NULL UNIQUE,
+			label VARCHAR(50) NOT NULL DEFAULT '',
+			permissions TEXT NOT NULL,
+			builtin TINYINT(1) NOT NULL DEFAULT 0,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
 
+		`CREATE TABLE IF NOT EXISTS password_history (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			user_id INT UNSIGNED NOT NULL,
+			password_hash VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_user (user_id, id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
 		`CREATE TABLE IF NOT EXISTS violations (
 			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
 			dorm VARCHAR(20) NOT NULL DEFAULT '',
@@ -74,14 +96,213 @@ func Migrate(db *sql.DB) error {
 			period VARCHAR(20) NOT NULL DEFAULT '',
 			reason TEXT NOT NULL,
 			department VARCHAR(30) NOT NULL DEFAULT '',
+			category VARCHAR(30) NOT NULL DEFAULT '',
 			inspector VARCHAR(100) NOT NULL DEFAULT '',
 			photo_path VARCHAR(500) NOT NULL DEFAULT '',
 			created_by INT UNSIGNED NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			revision_count INT UNSIGNED NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP NULL,
 			INDEX idx_created_at (created_at),
 			INDEX idx_created_by (created_by),
 			FOREIGN KEY (created_by) REFERENCES users(id)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS violation_deletions (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			violation_id INT UNSIGNED NOT NULL,
+			created_by INT UNSIGNED NOT NULL,
+			inspector VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NULL,
+			deleted_by INT UNSIGNED NOT NULL,
+			deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_created (created_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS appeals (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			violation_id INT UNSIGNED NOT NULL,
+			user_id INT UNSIGNED NOT NULL,
+			reason TEXT NOT NULL,
+			status ENUM('pending','accepted','rejected') NOT NULL DEFAULT 'pending',
+			reply TEXT,
+			handled_by INT UNSIGNED NULL,
+			handled_at TIMESTAMP NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_status (status, created_at),
+			INDEX idx_violation (violation_id),
+			FOREIGN KEY (violation_id) REFERENCES violations(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS categories (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(30) NOT NULL UNIQUE,
+			points INT NOT NULL DEFAULT 1,
+			sort_order INT NOT NULL DEFAULT 0
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS classes (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(50) NOT NULL UNIQUE,
+			grade VARCHAR(20) NOT NULL DEFAULT '',
+			student_count INT UNSIGNED NOT NULL DEFAULT 0,
+			INDEX idx_grade (grade)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS class_week_ranks (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			week_start DATE NOT NULL,
+			grade VARCHAR(20) NOT NULL,
+			class_name VARCHAR(50) NOT NULL,
+			student_count INT UNSIGNED NOT NULL,
+			violation_count INT UNSIGNED NOT NULL,
+			points INT NOT NULL,
+			rate DECIMAL(8,2) NOT NULL,
+			rank_no INT UNSIGNED NOT NULL,
+			UNIQUE KEY uk_week_class (week_start, class_name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS red_flag_awards (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			week_start DATE NOT NULL,
+			grade VARCHAR(20) NOT NULL,
+			class_name VARCHAR(50) NOT NULL,
+			points INT NOT NULL,
+			rate DECIMAL(8,2) NOT NULL,
+			locked_by INT UNSIGNED NOT NULL,
+			locked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uk_week_class (week_start, class_name),
+			INDEX idx_grade_week (grade, week_start)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS displays (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(50) NOT NULL UNIQUE,
+			mask_level ENUM('none','partial','strict') NOT NULL DEFAULT 'partial',
+			building VARCHAR(20) NOT NULL DEFAULT '',
+			grade VARCHAR(20) NOT NULL DEFAULT '',
+			token_hash CHAR(64) NULL UNIQUE,
+			token_prefix VARCHAR(12) NOT NULL DEFAULT '',
+			revoked_at TIMESTAMP NULL,
+			last_seen_at TIMESTAMP NULL,
+			last_seen_ip VARCHAR(45) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS notice_approvals (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			kind ENUM('day','week') NOT NULL,
+			start_date DATE NOT NULL,
+			approved_by INT UNSIGNED NOT NULL,
+			approved_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uk_kind_start (kind, start_date)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS announcements (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			title VARCHAR(100) NOT NULL,
+			body TEXT NOT NULL,
+			audience ENUM('public','staff','all') NOT NULL DEFAULT 'all',
+			pinned TINYINT(1) NOT NULL DEFAULT 0,
+			publish_at DATETIME NOT NULL,
+			expire_at DATETIME NULL,
+			created_by INT UNSIGNED NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NULL,
+			INDEX idx_publish (publish_at),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS user_classes (
+			user_id INT UNSIGNED NOT NULL,
+			class_name VARCHAR(50) NOT NULL,
+			PRIMARY KEY (user_id, class_name),
+			INDEX idx_class (class_name),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (class_name) REFERENCES classes(name) ON DELETE CASCADE ON UPDATE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS login_throttle (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			scope ENUM('user','ip') NOT NULL,
+			subject VARCHAR(100) NOT NULL,
+			failures INT UNSIGNED NOT NULL DEFAULT 0,
+			last_failure_at DATETIME NOT NULL,
+			locked_until DATETIME NULL,
+			UNIQUE KEY uk_scope_subject (scope, subject)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			jti CHAR(32) NOT NULL UNIQUE,
+			user_id INT UNSIGNED NOT NULL,
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME NULL,
+			revoked_reason VARCHAR(30) NOT NULL DEFAULT '',
+			INDEX idx_user (user_id, revoked_at),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			session_id INT UNSIGNED NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME NULL,
+			INDEX idx_session (session_id),
+			FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			actor_id INT UNSIGNED NOT NULL DEFAULT 0,
+			actor_name VARCHAR(100) NOT NULL DEFAULT '',
+			action VARCHAR(50) NOT NULL,
+			target_type VARCHAR(30) NOT NULL DEFAULT '',
+			target_id VARCHAR(100) NOT NULL DEFAULT '',
+			ip VARCHAR(45) NOT NULL DEFAULT '',
+			user_agent VARCHAR(255) NOT NULL DEFAULT '',
+			before_data TEXT,
+			after_data TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_created (created_at),
+			INDEX idx_action (action, created_at),
+			INDEX idx_target (target_type, target_id),
+			INDEX idx_actor (actor_id, created_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS report_jobs (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			cron VARCHAR(100) NOT NULL,
+			format ENUM('csv','xlsx','pdf') NOT NULL DEFAULT 'csv',
+			range_name VARCHAR(20) NOT NULL,
+			building VARCHAR(20) NOT NULL DEFAULT '',
+			period VARCHAR(20) NOT NULL DEFAULT '',
+			auto_created TINYINT(1) NOT NULL DEFAULT 0,
+			retention INT UNSIGNED NOT NULL DEFAULT 0,
+			enabled TINYINT(1) NOT NULL DEFAULT 1,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+
+		`CREATE TABLE IF NOT EXISTS report_job_runs (
+			id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			job_id INT UNSIGNED NOT NULL,
+			status ENUM('running','success','failed') NOT NULL DEFAULT 'running',
+			file_path VARCHAR(500) NOT NULL DEFAULT '',
+			file_size BIGINT NOT NULL DEFAULT 0,
+			error TEXT,
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP NULL,
+			INDEX idx_job_started (job_id, started_at),
+			FOREIGN KEY (job_id) REFERENCES report_jobs(id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
 	}
 
 	for _, q := range queries {
@@ -90,6 +311,67 @@ func Migrate(db *sql.DB) error {
 		}
 	}
 
+	// Columns added after the first release. MySQL has no
+	// ADD COLUMN IF NOT EXISTS, so check information_schema first.
+	columns := []struct{ table, column, def string }{
+		{"violations", "category", "VARCHAR(30) NOT NULL DEFAULT '' AFTER department"},
+		{"violations", "revision_count", "INT UNSIGNED NOT NULL DEFAULT 0"},
+		{"violations", "updated_at", "TIMESTAMP NULL"},
+		{"users", "must_change_password", "TINYINT(1) NOT NULL DEFAULT 0 AFTER role"},
+		{"users", "password_changed_at", "TIMESTAMP NULL AFTER must_change_password"},
+		{"users", "department", "VARCHAR(30) NOT NULL DEFAULT '' AFTER role"},
+		{"users", "building", "VARCHAR(20) NOT NULL DEFAULT '' AFTER department"},
+		{"report_jobs", "building", "VARCHAR(20) NOT NULL DEFAULT '' AFTER range_name"},
+		{"report_jobs", "period", "VARCHAR(20) NOT NULL DEFAULT '' AFTER building"},
+		{"report_jobs", "auto_created", "TINYINT(1) NOT NULL DEFAULT 0 AFTER period"},
+		{"displays", "building", "VARCHAR(20) NOT NULL DEFAULT ''"},
+		{"displays", "grade", "VARCHAR(20) NOT NULL DEFAULT ''"},
+		{"displays", "token_hash", "CHAR(64) NULL UNIQUE"},
+		{"displays", "token_prefix", "VARCHAR(12) NOT NULL DEFAULT ''"},
+		{"displays", "revoked_at", "TIMESTAMP NULL"},
+		{"displays", "last_seen_at", "TIMESTAMP NULL"},
+		{"displays", "last_seen_ip", "VARCHAR(45) NOT NULL DEFAULT ''"},
+	}
+	for _, col := range columns {
+		if err := addColumn(db, col.table, col.column, col.def); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+
+	// users.role started as ENUM('admin','staff'); it now names a row in
+	// roles.
+	if err := modifyColumn(db, "users", "role", "varchar", "VARCHAR(30) NOT NULL DEFAULT 'staff'"); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
 	log.Println("Database migration completed")
 	return nil
 }
+
+func addColumn(db *sql.DB, table, column, def string) error {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		table, column,
+	).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err
+}
+
+// modifyColumn changes a column's definition unless its type already is
+// dataType (as information_schema spells it, e.g. "varchar").
+func modifyColumn(db *sql.DB, table, column, dataType, def string) error {
+	var current string
+	err := db.QueryRow(
+		"SELECT DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		table, column,
+	).Scan(&current)
+	if err != nil || current == dataType {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, def))
+	return err
+}
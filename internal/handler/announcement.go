@@ -0,0 +1,229 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+)
+
+const announcementSelect = `
+	SELECT a.id, a.title, a.body, a.audience, a.pinned, a.publish_at, a.expire_at,
+	       a.created_by, COALESCE(u.display_name, u.username, ''), a.created_at
+	FROM announcements a
+	LEFT JOIN users u ON u.id = a.created_by `
+
+// ==================== Announcements ====================
+
+// ListAnnouncements returns every announcement, including scheduled and
+// expired ones, for the admin page.
+func (h *Handler) ListAnnouncements(c *gin.Context) {
+	list, err := h.queryAnnouncements("ORDER BY a.pinned DESC, a.publish_at DESC LIMIT 200")
+	if err != nil {
+		log.Printf("List announcements error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// GetPublicAnnouncements returns what the public screens show right now.
+// Display tokens are checked like the violation feed.
+func (h *Handler) GetPublicAnnouncements(c *gin.Context) {
+	if _, ok := h.publicScope(c); !ok {
+		return
+	}
+	h.activeAnnouncements(c, "public")
+}
+
+// GetStaffAnnouncements returns what logged-in members see.
+func (h *Handler) GetStaffAnnouncements(c *gin.Context) {
+	h.activeAnnouncements(c, "staff")
+}
+
+func (h *Handler) activeAnnouncements(c *gin.Context, audience string) {
+	// publish_at and expire_at are written from Go's clock, so compare
+	// against it rather than the database's NOW().
+	now := time.Now()
+	list, err := h.queryAnnouncements(`
+		WHERE a.audience IN (?, 'all') AND a.publish_at <= ?
+		  AND (a.expire_at IS NULL OR a.expire_at > ?)
+		ORDER BY a.pinned DESC, a.publish_at DESC`, audience, now, now)
+	if err != nil {
+		log.Printf("Active announcements error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	if audience == "public" {
+		// Screens have no business knowing who posted.
+		for i := range list {
+			list[i].CreatedBy = 0
+			list[i].CreatorName = ""
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) CreateAnnouncement(c *gin.Context) {
+	user := getUser(c)
+	req, publishAt, expireAt, ok := bindAnnouncement(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.db.Exec(
+		`INSERT INTO announcements (title, body, audience, pinned, publish_at, expire_at, created_by)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		req.Title, req.Body, req.Audience, req.Pinned, publishAt, expireAt, user.UserID,
+	)
+	if err != nil {
+		log.Printf("Create announcement error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusOK, gin.H{"id": id, "message": "公告已创建"})
+}
+
+func (h *Handler) UpdateAnnouncement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	req, publishAt, expireAt, ok := bindAnnouncement(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.db.Exec(
+		`UPDATE announcements SET title = ?, body = ?, audience = ?, pinned = ?, publish_at = ?, expire_at = ?,
+		        updated_at = ?
+		 WHERE id = ?`,
+		req.Title, req.Body, req.Audience, req.Pinned, publishAt, expireAt, time.Now(), id,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "公告不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+func (h *Handler) DeleteAnnouncement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM announcements WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "公告不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+func (h *Handler) queryAnnouncements(tail string, args ...interface{}) ([]model.Announcement, error) {
+	rows, err := h.db.Query(announcementSelect+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []model.Announcement{}
+	for rows.Next() {
+		var a model.Announcement
+		var expire sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Title, &a.Body, &a.Audience, &a.Pinned, &a.PublishAt, &expire,
+			&a.CreatedBy, &a.CreatorName, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if expire.Valid {
+			a.ExpireAt = &expire.Time
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func bindAnnouncement(c *gin.Context) (model.AnnouncementRequest, time.Time, *time.Time, bool) {
+	var req model.AnnouncementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return req, time.Time{}, nil, false
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Title == "" || req.Body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "标题和内容不能为空"})
+		return req, time.Time{}, nil, false
+	}
+
+	publishAt := time.Now()
+	if req.PublishAt != "" {
+		t, err := parseLocalTime(req.PublishAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "发布时间格式错误"})
+			return req, time.Time{}, nil, false
+		}
+		publishAt = t
+	}
+	var expireAt *time.Time
+	if req.ExpireAt != "" {
+		t, err := parseLocalTime(req.ExpireAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间格式错误"})
+			return req, time.Time{}, nil, false
+		}
+		if !t.After(publishAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "过期时间必须晚于发布时间"})
+			return req, time.Time{}, nil, false
+		}
+		expireAt = &t
+	}
+	return req, publishAt, expireAt, true
+}
+
+// parseLocalTime accepts what <input type="datetime-local"> sends as well
+// as plain dates and RFC 3339.
+func parseLocalTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Local(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+)
+
+// ==================== Appeals ====================
+
+// CreateAppeal files an appeal against a record the user can read. Only
+// one appeal per record and user can be pending at a time.
+func (h *Handler) CreateAppeal(c *gin.Context) {
+	idNum, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idNum < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录 ID"})
+		return
+	}
+	var req model.AppealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写申诉理由"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写申诉理由"})
+		return
+	}
+
+	v, err := h.getViolation(uint(idNum))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	if scope := scopeFor(c, perm.ViolationRead); !scope.allows(v) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+
+	user := getUser(c)
+	var pending int
+	h.db.QueryRow("SELECT COUNT(*) FROM appeals WHERE violation_id = ? AND user_id = ? AND status = 'pending'",
+		idNum, user.UserID).Scan(&pending)
+	if pending > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "该记录已有待处理的申诉"})
+		return
+	}
+
+	result, err := h.db.Exec("INSERT INTO appeals (violation_id, user_id, reason) VALUES (?, ?, ?)",
+		idNum, user.UserID, req.Reason)
+	if err != nil {
+		log.Printf("Create appeal error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	h.audit(c, "appeal.create", "appeal", id, nil, gin.H{"violation_id": idNum, "reason": req.Reason})
+	c.JSON(http.StatusOK, gin.H{"id": id, "message": "申诉已提交"})
+}
+
+// ListAppeals shows handlers the appeals on records they can edit and
+// everyone else the appeals they filed. ?status= filters.
+func (h *Handler) ListAppeals(c *gin.Context) {
+	user := getUser(c)
+	var cond string
+	var args []interface{}
+	if user.Perms.Has(perm.AppealHandle) {
+		cond, args = scopeFor(c, perm.ViolationUpdate).where()
+	} else {
+		cond, args = " AND a.user_id = ?", []interface{}{user.UserID}
+	}
+	if status := c.Query("status"); status != "" {
+		cond += " AND a.status = ?"
+		args = append(args, status)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT a.id, a.violation_id, v.student_name, v.class_name, v.reason,
+		       a.user_id, COALESCE(u.display_name, u.username, ''), a.reason, a.status,
+		       COALESCE(a.reply, ''), COALESCE(hu.display_name, hu.username, ''), a.handled_at, a.created_at
+		FROM appeals a
+		JOIN violations v ON v.id = a.violation_id
+		LEFT JOIN users u ON u.id = a.user_id
+		LEFT JOIN users hu ON hu.id = a.handled_by
+		WHERE 1=1`+cond+`
+		ORDER BY a.status = 'pending' DESC, a.created_at DESC
+		LIMIT 500
+	`, args...)
+	if err != nil {
+		log.Printf("List appeals error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	list := []model.Appeal{}
+	for rows.Next() {
+		var a model.Appeal
+		var handledAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.ViolationID, &a.StudentName, &a.ClassName, &a.Violation,
+			&a.UserID, &a.UserName, &a.Reason, &a.Status,
+			&a.Reply, &a.HandlerName, &handledAt, &a.CreatedAt); err != nil {
+			continue
+		}
+		if handledAt.Valid {
+			a.HandledAt = &handledAt.Time
+		}
+		list = append(list, a)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// HandleAppeal accepts or rejects a pending appeal on a record the user
+// can edit. Correcting or deleting the record is a separate step.
+func (h *Handler) HandleAppeal(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var req model.AppealDecision
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	var violationID uint
+	var status string
+	err := h.db.QueryRow("SELECT violation_id, status FROM appeals WHERE id = ?", id).Scan(&violationID, &status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "申诉不存在"})
+		return
+	}
+	if status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "申诉已处理"})
+		return
+	}
+	v, err := h.getViolation(violationID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	if scope := scopeFor(c, perm.ViolationUpdate); !scope.allows(v) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+
+	req.Reply = strings.TrimSpace(req.Reply)
+	result, err := h.db.Exec(`UPDATE appeals SET status = ?, reply = ?, handled_by = ?, handled_at = NOW()
+		WHERE id = ? AND status = 'pending'`, req.Status, req.Reply, getUser(c).UserID, id)
+	if err != nil {
+		log.Printf("Handle appeal error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "申诉已处理"})
+		return
+	}
+	h.audit(c, "appeal."+req.Status, "appeal", id,
+		gin.H{"status": "pending"}, gin.H{"status": req.Status, "reply": req.Reply, "violation_id": violationID})
+	c.JSON(http.StatusOK, gin.H{"message": "已处理"})
+}
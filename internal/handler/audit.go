@@ -0,0 +1,246 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+)
+
+// audit records an action by the logged-in user. before and after are
+// marshalled to JSON; pass nil when there is nothing to show. Failures are
+// logged, never returned: the action itself has already happened.
+func (h *Handler) audit(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}) {
+	var actorID uint
+	actorName := ""
+	if v, ok := c.Get("user"); ok {
+		u := v.(model.Claims)
+		actorID, actorName = u.UserID, u.Username
+	}
+	h.auditAs(c, actorID, actorName, action, targetType, targetID, before, after)
+}
+
+// auditAs is audit for requests without a session, e.g. logins.
+func (h *Handler) auditAs(c *gin.Context, actorID uint, actorName, action, targetType string, targetID interface{}, before, after interface{}) {
+	c.Set("audited", true)
+
+	id := ""
+	if targetID != nil {
+		id = fmt.Sprint(targetID)
+	}
+	_, err := h.db.Exec(
+		`INSERT INTO audit_log (actor_id, actor_name, action, target_type, target_id, ip, user_agent, before_data, after_data)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		actorID, clip(actorName, 100), action, targetType, id, c.ClientIP(), userAgent(c), auditJSON(before), auditJSON(after),
+	)
+	if err != nil {
+		log.Printf("Audit log error (%s): %v", action, err)
+	}
+}
+
+// userAgent is the request's User-Agent as it fits a VARCHAR(255) column.
+func userAgent(c *gin.Context) string {
+	return clip(c.Request.UserAgent(), 255)
+}
+
+// clip makes s valid UTF-8 of at most n characters (VARCHAR(n) counts
+// characters, and MySQL rejects a rune cut in half).
+func clip(s string, n int) string {
+	s = strings.ToValidUTF8(s, "")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+func auditJSON(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// AuditMutations logs every successful non-GET API call that its handler
+// did not already audit in detail, so new endpoints are covered without
+// anyone remembering to add a call. Install it after JWTAuth.
+func (h *Handler) AuditMutations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if c.GetBool("audited") || c.Writer.Status() >= 400 {
+			return
+		}
+		params := gin.H{}
+		for _, p := range c.Params {
+			params[p.Key] = p.Value
+		}
+		var after interface{}
+		if len(params) > 0 {
+			after = params
+		}
+		h.audit(c, "api."+strings.ToLower(c.Request.Method), "route", c.FullPath(), nil, after)
+	}
+}
+
+// auditFilter builds the WHERE clause shared by the list and the export:
+// actor (username), action (prefix match, e.g. "violation."), target_type,
+// target_id, ip and a date range (start=/end=/range=, optional).
+func auditFilter(c *gin.Context) (string, []interface{}, error) {
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	if v := strings.TrimSpace(c.Query("actor")); v != "" {
+		where += " AND actor_name = ?"
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(c.Query("action")); v != "" {
+		where += " AND action LIKE ?"
+		args = append(args, strings.ReplaceAll(v, "%", `\%`)+"%")
+	}
+	if v := strings.TrimSpace(c.Query("target_type")); v != "" {
+		where += " AND target_type = ?"
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(c.Query("target_id")); v != "" {
+		where += " AND target_id = ?"
+		args = append(args, v)
+	}
+	if v := strings.TrimSpace(c.Query("ip")); v != "" {
+		where += " AND ip = ?"
+		args = append(args, v)
+	}
+	if c.Query("range") != "" || c.Query("date") != "" || c.Query("start") != "" || c.Query("end") != "" {
+		start, end, err := parseDateRange(c)
+		if err != nil {
+			return "", nil, err
+		}
+		where += " AND created_at >= ? AND created_at < ?"
+		args = append(args, start, end)
+	}
+	return where, args, nil
+}
+
+const auditColumns = `SELECT id, actor_id, actor_name, action, target_type, target_id, ip, user_agent,
+	COALESCE(before_data, ''), COALESCE(after_data, ''), created_at FROM audit_log `
+
+func scanAudit(rows interface{ Scan(...interface{}) error }) (model.AuditEntry, error) {
+	var e model.AuditEntry
+	err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID, &e.IP, &e.UserAgent,
+		&e.Before, &e.After, &e.CreatedAt)
+	return e, err
+}
+
+// ==================== Audit Log ====================
+
+func (h *Handler) ListAuditLog(c *gin.Context) {
+	where, args, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	var total int
+	h.db.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&total)
+
+	rows, err := h.db.Query(auditColumns+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		log.Printf("Audit log query error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	list := []model.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, e)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+// ExportAuditLog streams the filtered log as CSV. The export is itself
+// audited.
+func (h *Handler) ExportAuditLog(c *gin.Context) {
+	where, args, err := auditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := h.db.Query(auditColumns+where+" ORDER BY id", args...)
+	if err != nil {
+		log.Printf("Audit log export error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	setAttachment(c, "audit-"+time.Now().Format("20060102-150405")+".csv")
+	c.Writer.WriteString("\xEF\xBB\xBF")
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"ID", "时间", "操作人ID", "操作人", "操作", "对象类型", "对象ID", "IP", "User-Agent", "修改前", "修改后"})
+	for n := 1; rows.Next(); n++ {
+		e, err := scanAudit(rows)
+		if err != nil {
+			continue
+		}
+		w.Write([]string{
+			strconv.FormatUint(e.ID, 10), e.CreatedAt.Format("2006-01-02 15:04:05"),
+			strconv.FormatUint(uint64(e.ActorID), 10), e.ActorName, e.Action, e.TargetType, e.TargetID,
+			e.IP, e.UserAgent, e.Before, e.After,
+		})
+		if n%500 == 0 {
+			w.Flush()
+		}
+	}
+	w.Flush()
+	err = rows.Err()
+	if err == nil {
+		err = w.Error()
+	}
+	h.auditExport(c, "export.audit_log", "", nil, err)
+	if err != nil {
+		log.Printf("Audit log export error: %v", err)
+	}
+}
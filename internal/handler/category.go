@@ -0,0 +1,133 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+)
+
+// ==================== Categories ====================
+
+func (h *Handler) ListCategories(c *gin.Context) {
+	rows, err := h.db.Query("SELECT id, name, points, sort_order FROM categories ORDER BY sort_order, id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	categories := []model.Category{}
+	for rows.Next() {
+		var cat model.Category
+		rows.Scan(&cat.ID, &cat.Name, &cat.Points, &cat.SortOrder)
+		categories = append(categories, cat)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": categories})
+}
+
+func (h *Handler) CreateCategory(c *gin.Context) {
+	var req model.CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec("INSERT INTO categories (name, points, sort_order) VALUES (?, ?, ?)",
+		strings.TrimSpace(req.Name), req.Points, req.SortOrder)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "类别已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "类别创建成功"})
+}
+
+// UpdateCategory changes points and order. Renaming also rewrites the
+// category on existing violations so statistics stay continuous.
+func (h *Handler) UpdateCategory(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var req model.CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	if err := tx.QueryRow("SELECT name FROM categories WHERE id = ? FOR UPDATE", id).Scan(&oldName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "类别不存在"})
+		return
+	}
+	if _, err := tx.Exec("UPDATE categories SET name = ?, points = ?, sort_order = ? WHERE id = ?",
+		req.Name, req.Points, req.SortOrder, id); err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "类别已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if oldName != req.Name {
+		if _, err := tx.Exec("UPDATE violations SET category = ? WHERE category = ?", req.Name, oldName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+// DeleteCategory removes the category from the list. Existing violations
+// keep the name they were recorded with.
+func (h *Handler) DeleteCategory(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM categories WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "类别不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
@@ -0,0 +1,139 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+)
+
+// ==================== Class Roster ====================
+
+func (h *Handler) ListClasses(c *gin.Context) {
+	where := ""
+	args := []interface{}{}
+	if grade := c.Query("grade"); grade != "" {
+		where = " WHERE grade = ?"
+		args = append(args, grade)
+	}
+
+	rows, err := h.db.Query("SELECT id, name, grade, student_count FROM classes"+where+" ORDER BY grade, name", args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	classes := []model.Class{}
+	for rows.Next() {
+		var cl model.Class
+		rows.Scan(&cl.ID, &cl.Name, &cl.Grade, &cl.StudentCount)
+		classes = append(classes, cl)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": classes})
+}
+
+func (h *Handler) CreateClass(c *gin.Context) {
+	var req model.ClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec("INSERT INTO classes (name, grade, student_count) VALUES (?, ?, ?)",
+		strings.TrimSpace(req.Name), strings.TrimSpace(req.Grade), req.StudentCount)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "班级已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "班级创建成功"})
+}
+
+func (h *Handler) UpdateClass(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var req model.ClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	if err := tx.QueryRow("SELECT name FROM classes WHERE id = ? FOR UPDATE", id).Scan(&oldName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "班级不存在"})
+		return
+	}
+	// Teacher links follow by ON UPDATE CASCADE; records are rewritten so
+	// the class keeps its history and its teachers still see it.
+	if _, err := tx.Exec("UPDATE classes SET name = ?, grade = ?, student_count = ? WHERE id = ?",
+		req.Name, strings.TrimSpace(req.Grade), req.StudentCount, id); err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "班级已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if oldName != req.Name {
+		if _, err := tx.Exec("UPDATE violations SET class_name = ? WHERE class_name = ?", req.Name, oldName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+func (h *Handler) DeleteClass(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM classes WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "班级不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
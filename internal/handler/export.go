@@ -0,0 +1,590 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+	"suv/internal/report"
+)
+
+func (h *Handler) ExportCSV(c *gin.Context) {
+	h.exportRange(c, "csv", "violations")
+}
+
+func (h *Handler) ExportXLSX(c *gin.Context) {
+	h.exportRange(c, "xlsx", "violations")
+}
+
+func (h *Handler) ExportPDF(c *gin.Context) {
+	h.exportRange(c, "pdf", "notice")
+}
+
+var reportContentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"pdf":  "application/pdf",
+}
+
+func (h *Handler) exportRange(c *gin.Context, format, prefix string) {
+	scope, ok := requireScope(c, perm.Export)
+	if !ok {
+		return
+	}
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", reportContentTypes[format])
+	setAttachment(c, rangeFilename(prefix, start, end, format))
+
+	err = h.writeReport(c.Writer, format, start, end, scope, c.Query("period"))
+	h.auditExport(c, "export."+format, "", nil, err)
+	if err != nil {
+		log.Printf("Export %s error: %v", format, err)
+		// Nothing sent yet (the query failed): answer with a normal error.
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "导出失败"})
+		}
+	}
+}
+
+// auditExport records an export once it has been written, so that one
+// which broke off is logged as failed rather than as delivered.
+func (h *Handler) auditExport(c *gin.Context, action, targetType string, targetID interface{}, err error) {
+	after := gin.H{"query": c.Request.URL.RawQuery}
+	if err != nil {
+		after["failed"] = true
+	}
+	h.audit(c, action, targetType, targetID, nil, after)
+}
+
+// WriteReport renders the violations in [start, end) as csv, xlsx or pdf.
+// It backs scheduled report jobs, which cover every record unless the job
+// is limited to a building or period.
+func (h *Handler) WriteReport(w io.Writer, job model.ReportJob, start, end time.Time) error {
+	scope := recordScope{level: perm.Any}
+	if job.Building != "" {
+		scope = recordScope{level: perm.Building, building: job.Building}
+	}
+	return h.writeReport(w, job.Format, start, end, scope, job.Period)
+}
+
+// writeReport is WriteReport limited to scope, for the export endpoints.
+// A non-empty period keeps only records of that period.
+func (h *Handler) writeReport(w io.Writer, format string, start, end time.Time, scope recordScope, period string) error {
+	cond, condArgs := scope.where()
+	where := "WHERE v.created_at >= ? AND v.created_at < ?" + cond
+	args := append([]interface{}{start, end}, condArgs...)
+	if period != "" {
+		where += " AND v.period = ?"
+		args = append(args, period)
+	}
+
+	switch format {
+	case "csv":
+		return h.writeCSV(w, where, args)
+	case "xlsx":
+		return h.writeXLSX(w, where, args)
+	case "pdf":
+		violations, err := h.queryViolations(where+" ORDER BY v.class_name, v.created_at", args...)
+		if err != nil {
+			return err
+		}
+		return report.WriteNoticePDF(w, report.Notice{
+			School:     h.cfg.SchoolName,
+			Start:      start,
+			End:        end,
+			Violations: violations,
+		})
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+var exportColumns = []string{"ID", "宿舍号", "姓名", "班级", "时间段", "类别", "违纪原因", "部门", "执勤人", "记录时间", "录入人"}
+
+func exportRow(v model.Violation) []string {
+	return []string{
+		strconv.FormatUint(uint64(v.ID), 10), v.Dorm, v.StudentName, v.ClassName, v.Period, v.Category, v.Reason,
+		v.Department, v.Inspector, v.CreatedAt.Format("2006-01-02 15:04:05"), v.CreatorName,
+	}
+}
+
+func (h *Handler) writeCSV(out io.Writer, where string, args []interface{}) error {
+	var w *csv.Writer
+	flusher, _ := out.(http.Flusher)
+
+	// Rows are written as they come off the connection and flushed in
+	// batches, so a full year's export never sits in memory at once. The
+	// BOM and header go out with the first row so a failed query can still
+	// be reported as an error.
+	n := 0
+	err := h.eachViolation(where+" ORDER BY v.created_at ASC", args, func(v model.Violation) error {
+		if w == nil {
+			w = newExportCSV(out)
+		}
+		w.Write(exportRow(v))
+		n++
+		if n%500 == 0 {
+			w.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return w.Error()
+	})
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = newExportCSV(out)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func newExportCSV(out io.Writer) *csv.Writer {
+	// BOM for Excel UTF-8 compatibility
+	io.WriteString(out, "\xEF\xBB\xBF")
+	w := csv.NewWriter(out)
+	w.Write(exportColumns)
+	return w
+}
+
+func (h *Handler) writeXLSX(out io.Writer, where string, args []interface{}) error {
+	var x *report.XLSX
+	err := h.eachViolation(where+" ORDER BY v.created_at ASC", args, func(v model.Violation) (err error) {
+		if x == nil {
+			if x, err = newExportXLSX(out); err != nil {
+				return err
+			}
+		}
+		return x.WriteRow(exportRow(v))
+	})
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		if x, err = newExportXLSX(out); err != nil {
+			return err
+		}
+	}
+	return x.Close()
+}
+
+func newExportXLSX(out io.Writer) (*report.XLSX, error) {
+	x, err := report.NewXLSX(out, "违纪记录")
+	if err != nil {
+		return nil, err
+	}
+	return x, x.WriteRow(exportColumns)
+}
+
+func (h *Handler) ExportClassPack(c *gin.Context) {
+	scope, ok := requireScope(c, perm.Export)
+	if !ok {
+		return
+	}
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cond, condArgs := scope.where()
+
+	summary, err := h.classSummary(start, end, scope)
+	if err != nil {
+		log.Printf("Class pack summary error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	setAttachment(c, rangeFilename("classes", start, end, "zip"))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	// Rows arrive ordered by class, so each class file is finished before
+	// the next one starts and only one row is held at a time.
+	var cur *report.ClassReport
+	curClass := ""
+	err = h.eachViolation(
+		"WHERE v.created_at >= ? AND v.created_at < ?"+cond+" ORDER BY v.class_name, v.created_at",
+		append([]interface{}{start, end}, condArgs...),
+		func(v model.Violation) error {
+			if cur == nil || v.ClassName != curClass {
+				if cur != nil {
+					if err := cur.Close(); err != nil {
+						return err
+					}
+				}
+				f, err := zw.CreateHeader(&zip.FileHeader{
+					Name:     report.SafeFilename(v.ClassName) + ".csv",
+					Method:   zip.Deflate,
+					Modified: time.Now(),
+				})
+				if err != nil {
+					return err
+				}
+				if cur, err = report.NewClassReport(f, summary, v.ClassName, start, end); err != nil {
+					return err
+				}
+				curClass = v.ClassName
+			}
+			return cur.Row(v)
+		})
+	if err == nil && cur != nil {
+		err = cur.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	h.auditExport(c, "export.class_pack", "", nil, err)
+	if err != nil {
+		log.Printf("Class pack write error: %v", err)
+	}
+}
+
+func (h *Handler) ExportClassReport(c *gin.Context) {
+	scope, ok := requireScope(c, perm.Export)
+	if !ok {
+		return
+	}
+	cond, condArgs := scope.where()
+	class := strings.TrimSpace(c.Query("class"))
+	if class == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定班级"})
+		return
+	}
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := h.classSummary(start, end, scope)
+	if err != nil {
+		log.Printf("Class report summary error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	setAttachment(c, rangeFilename(report.SafeFilename(class), start, end, "csv"))
+	c.Status(http.StatusOK)
+
+	r, err := report.NewClassReport(c.Writer, summary, class, start, end)
+	if err == nil {
+		err = h.eachViolation(
+			"WHERE v.class_name = ? AND v.created_at >= ? AND v.created_at < ?"+cond+" ORDER BY v.created_at",
+			append([]interface{}{class, start, end}, condArgs...), r.Row)
+	}
+	if err == nil {
+		err = r.Close()
+	}
+	h.auditExport(c, "export.class_report", "class", class, err)
+	if err != nil {
+		log.Printf("Class report write error: %v", err)
+	}
+}
+
+func (h *Handler) ExportPhotos(c *gin.Context) {
+	scope, ok := requireScope(c, perm.Export)
+	if !ok {
+		return
+	}
+	where, args := scope.where()
+	where = "WHERE v.photo_path <> ''" + where
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	if idsStr := c.Query("ids"); idsStr != "" {
+		ids := []string{}
+		for _, s := range strings.Split(idsStr, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || id < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录 ID"})
+				return
+			}
+			ids = append(ids, "?")
+			args = append(args, id)
+		}
+		if len(ids) > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "一次最多导出 500 条记录"})
+			return
+		}
+		where += " AND v.id IN (" + strings.Join(ids, ",") + ")"
+	} else {
+		start, end, err := parseDateRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		where += " AND v.created_at >= ? AND v.created_at < ?"
+		args = append(args, start, end)
+	}
+
+	if class := c.Query("class"); class != "" {
+		where += " AND v.class_name = ?"
+		args = append(args, class)
+	}
+	if name := c.Query("student"); name != "" {
+		where += " AND v.student_name = ?"
+		args = append(args, name)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		where += " AND (v.student_name LIKE ? OR v.class_name LIKE ? OR v.dorm LIKE ? OR v.reason LIKE ?)"
+		kw := "%" + keyword + "%"
+		args = append(args, kw, kw, kw, kw)
+	}
+
+	c.Header("Content-Type", "application/zip")
+	setAttachment(c, fmt.Sprintf("photos_%s.zip", time.Now().Format("20060102_150405")))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	defer zw.Close()
+
+	// The manifest goes in last, so it is collected while the photos are
+	// written. It is only text and stays small next to the images.
+	var manifest bytes.Buffer
+	manifest.WriteString("\xEF\xBB\xBF")
+	mw := csv.NewWriter(&manifest)
+	mw.Write([]string{"文件名", "ID", "记录时间", "班级", "姓名", "宿舍号", "时间段", "违纪原因", "部门", "执勤人", "备注"})
+
+	err := h.eachViolation(where+" ORDER BY v.created_at", args, func(v model.Violation) error {
+		name := fmt.Sprintf("%s_%s_%s_%d%s", v.CreatedAt.Format("20060102"),
+			report.SafeFilename(v.ClassName), report.SafeFilename(v.StudentName), v.ID,
+			strings.ToLower(filepath.Ext(v.PhotoPath)))
+		note := ""
+
+		src, err := os.Open(filepath.Join(h.cfg.UploadDir, filepath.Base(v.PhotoPath)))
+		if err != nil {
+			name, note = "", "照片文件缺失"
+		} else {
+			defer src.Close()
+			// Images are already compressed; storing them is much faster.
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: v.CreatedAt})
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, src); err != nil {
+				return err
+			}
+		}
+
+		return mw.Write([]string{
+			name, strconv.FormatUint(uint64(v.ID), 10), v.CreatedAt.Format("2006-01-02 15:04:05"),
+			v.ClassName, v.StudentName, v.Dorm, v.Period, v.Reason, v.Department, v.Inspector, note,
+		})
+	})
+	if err == nil {
+		mw.Flush()
+		var f io.Writer
+		f, err = zw.CreateHeader(&zip.FileHeader{Name: "manifest.csv", Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = f.Write(manifest.Bytes())
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	h.auditExport(c, "export.photos", "", nil, err)
+	if err != nil {
+		log.Printf("Photo export error: %v", err)
+	}
+}
+
+// classSummary counts violations per class and category for [start, end)
+// within scope. Every class on the roster is registered so the average
+// covers classes without violations.
+func (h *Handler) classSummary(start, end time.Time, scope recordScope) (*report.Summary, error) {
+	s := report.NewSummary()
+	// Clean classes pull the average down too. A class-scoped user only
+	// compares among their own classes.
+	if scope.level == perm.Class {
+		for _, name := range scope.classes {
+			s.AddClass(name)
+		}
+	} else {
+		roster, err := h.db.Query("SELECT name FROM classes")
+		if err != nil {
+			return nil, err
+		}
+		for roster.Next() {
+			var name string
+			if err := roster.Scan(&name); err != nil {
+				roster.Close()
+				return nil, err
+			}
+			s.AddClass(name)
+		}
+		roster.Close()
+	}
+
+	cond, condArgs := scope.where()
+	rows, err := h.db.Query(`
+		SELECT v.class_name, IF(v.category = '', '未分类', v.category) AS cat, COUNT(*)
+		FROM violations v
+		WHERE v.created_at >= ? AND v.created_at < ?`+cond+`
+		GROUP BY v.class_name, cat
+	`, append([]interface{}{start, end}, condArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var class, category string
+		var n int
+		if err := rows.Scan(&class, &category, &n); err != nil {
+			return nil, err
+		}
+		s.Add(class, category, n)
+	}
+	return s, rows.Err()
+}
+
+// queryViolations runs the standard violation SELECT (with creator name)
+// followed by the given WHERE/ORDER clause.
+func (h *Handler) queryViolations(clause string, args ...interface{}) ([]model.Violation, error) {
+	violations := []model.Violation{}
+	err := h.eachViolation(clause, args, func(v model.Violation) error {
+		violations = append(violations, v)
+		return nil
+	})
+	return violations, err
+}
+
+// eachViolation is the streaming form of queryViolations: fn is called for
+// every row as it is read, and a non-nil return stops the iteration.
+func (h *Handler) eachViolation(clause string, args []interface{}, fn func(model.Violation) error) error {
+	rows, err := h.db.Query(`
+		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
+		       v.department, v.category, v.inspector, v.photo_path, v.created_by, v.created_at,
+		       COALESCE(u.display_name, u.username) as creator_name
+		FROM violations v
+		LEFT JOIN users u ON v.created_by = u.id
+		`+clause, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v model.Violation
+		if err := rows.Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
+			&v.Department, &v.Category, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName); err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// setAttachment sets Content-Disposition with both an ASCII fallback and the
+// RFC 5987 UTF-8 name, since class names are usually Chinese.
+func setAttachment(c *gin.Context, filename string) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s",
+		asciiFilename(filename), url.PathEscape(filename)))
+}
+
+func asciiFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7E || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// parseDateRange reads ?range=week (see report.NamedRange), ?date=YYYY-MM-DD
+// or ?start=&end= (both inclusive) and returns the half-open interval
+// [start, end) in local time. With no parameters it defaults to today.
+func parseDateRange(c *gin.Context) (time.Time, time.Time, error) {
+	if name := c.Query("range"); name != "" {
+		start, end, ok := report.NamedRange(name, time.Now())
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("未知的日期范围")
+		}
+		return start, end, nil
+	}
+
+	startStr, endStr := c.Query("start"), c.Query("end")
+	if d := c.Query("date"); d != "" {
+		startStr, endStr = d, d
+	}
+	if startStr == "" && endStr == "" {
+		today := time.Now().Format("2006-01-02")
+		startStr, endStr = today, today
+	}
+	if startStr == "" {
+		startStr = endStr
+	}
+	if endStr == "" {
+		endStr = startStr
+	}
+
+	start, err := time.ParseInLocation("2006-01-02", startStr, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("日期格式错误")
+	}
+	end, err := time.ParseInLocation("2006-01-02", endStr, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("日期格式错误")
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("结束日期不能早于开始日期")
+	}
+	if end.Sub(start) > 366*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("日期范围不能超过一年")
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// rangeFilename builds e.g. violations_2025-03-01.csv or
+// violations_2025-03-01_2025-03-07.csv for a [start, end) range.
+func rangeFilename(prefix string, start, end time.Time, ext string) string {
+	last := end.AddDate(0, 0, -1)
+	if last.Equal(start) {
+		return fmt.Sprintf("%s_%s.%s", prefix, start.Format("2006-01-02"), ext)
+	}
+	return fmt.Sprintf("%s_%s_%s.%s", prefix, start.Format("2006-01-02"), last.Format("2006-01-02"), ext)
+}
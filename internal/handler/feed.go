@@ -0,0 +1,258 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/report"
+)
+
+// GetPublicFeed serves a day's or week's notice to the public screens:
+//
+//	?day=today|yesterday|2006-01-02
+//	?week=this|last|2006-01-02 (any day of the week)
+//
+// Today is always live; earlier days and weeks only once an admin has
+// approved them. Results are paged (page=, page_size=) so small screens
+// can cycle through the list. Masking and display scope apply as for
+// /api/violations/today.
+func (h *Handler) GetPublicFeed(c *gin.Context) {
+	scope, ok := h.publicScope(c)
+	if !ok {
+		return
+	}
+	kind, start, end, err := parseFeedPeriod(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if start.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能查看未来的公示"})
+		return
+	}
+
+	var approvedAt *time.Time
+	isToday := kind == "day" && start.Equal(report.Day(time.Now()))
+	if !isToday {
+		var at time.Time
+		err := h.db.QueryRow("SELECT approved_at FROM notice_approvals WHERE kind = ? AND start_date = ?",
+			kind, start.Format("2006-01-02")).Scan(&at)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "该公示尚未发布"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		approvedAt = &at
+	}
+
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+
+	scopeSQL, scopeArgs := scope.where()
+	where := "WHERE v.created_at >= ? AND v.created_at < ?" + scopeSQL
+	args := append([]interface{}{start, end}, scopeArgs...)
+
+	var total int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM violations v "+where, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	pages := (total + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	// Carousels just keep incrementing the page; wrap instead of serving
+	// empty slides.
+	page = (page-1)%pages + 1
+
+	rows, err := h.db.Query(`
+		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
+		       v.department, v.category, v.created_at
+		FROM violations v `+where+`
+		ORDER BY v.created_at DESC, v.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		log.Printf("Public feed error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	list := []model.PublicViolation{}
+	for rows.Next() {
+		var v model.Violation
+		if err := rows.Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
+			&v.Department, &v.Category, &v.CreatedAt); err != nil {
+			continue
+		}
+		list = append(list, publicView(v, scope.Mask))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"kind":        kind,
+		"title":       feedTitle(kind, start, end),
+		"start":       start.Format("2006-01-02"),
+		"end":         end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"live":        isToday,
+		"approved_at": approvedAt,
+		"total":       total,
+		"page":        page,
+		"pages":       pages,
+		"page_size":   pageSize,
+		"mask":        scope.Mask,
+		"data":        list,
+	})
+}
+
+func parseFeedPeriod(c *gin.Context) (string, time.Time, time.Time, error) {
+	now := time.Now()
+	if w := strings.TrimSpace(c.Query("week")); w != "" {
+		var ws time.Time
+		switch w {
+		case "this":
+			ws = report.WeekStart(now)
+		case "last":
+			ws = report.WeekStart(now).AddDate(0, 0, -7)
+		default:
+			d, err := time.ParseInLocation("2006-01-02", w, time.Local)
+			if err != nil {
+				return "", time.Time{}, time.Time{}, fmt.Errorf("日期格式错误")
+			}
+			ws = report.WeekStart(d)
+		}
+		return "week", ws, ws.AddDate(0, 0, 7), nil
+	}
+
+	var d time.Time
+	switch day := strings.TrimSpace(c.DefaultQuery("day", "today")); day {
+	case "today":
+		d = report.Day(now)
+	case "yesterday":
+		d = report.Day(now).AddDate(0, 0, -1)
+	default:
+		var err error
+		d, err = time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("日期格式错误")
+		}
+	}
+	return "day", d, d.AddDate(0, 0, 1), nil
+}
+
+func feedTitle(kind string, start, end time.Time) string {
+	if kind == "week" {
+		return fmt.Sprintf("%s 至 %s 违纪公示", start.Format("1月2日"), end.AddDate(0, 0, -1).Format("1月2日"))
+	}
+	return start.Format("2006年1月2日") + " 违纪公示"
+}
+
+// ==================== Notice Approvals ====================
+
+func (h *Handler) ListNoticeApprovals(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT a.id, a.kind, a.start_date, a.approved_by, COALESCE(u.display_name, u.username, ''), a.approved_at
+		FROM notice_approvals a
+		LEFT JOIN users u ON u.id = a.approved_by
+		ORDER BY a.start_date DESC, a.kind
+		LIMIT 200
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	list := []model.NoticeApproval{}
+	for rows.Next() {
+		var a model.NoticeApproval
+		var start time.Time
+		if err := rows.Scan(&a.ID, &a.Kind, &start, &a.ApprovedBy, &a.ApprovedByName, &a.ApprovedAt); err != nil {
+			continue
+		}
+		a.StartDate = start.Format("2006-01-02")
+		list = append(list, a)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// ApproveNotice publishes a past day (kind=day) or the week containing
+// date (kind=week) on the public feed.
+func (h *Handler) ApproveNotice(c *gin.Context) {
+	user := getUser(c)
+	var req model.NoticeApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+	start, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式错误"})
+		return
+	}
+	if req.Kind == "week" {
+		start = report.WeekStart(start)
+	}
+	if start.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能发布未来的公示"})
+		return
+	}
+
+	_, err = h.db.Exec(`INSERT INTO notice_approvals (kind, start_date, approved_by) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE approved_by = VALUES(approved_by), approved_at = NOW()`,
+		req.Kind, start.Format("2006-01-02"), user.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已发布", "start_date": start.Format("2006-01-02")})
+}
+
+func (h *Handler) WithdrawNotice(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM notice_approvals WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "公示不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已撤回"})
+}
@@ -31,17 +31,29 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 	"suv/internal/config"
+	"suv/internal/live"
+	"suv/internal/metrics"
 	"suv/internal/middleware"
 	"suv/internal/model"
+	"suv/internal/perm"
+	"suv/internal/scheduler"
 )
 
 type Handler struct {
-	db  *sql.DB
-	cfg *config.Config
+	db    *sql.DB
+	cfg   *config.Config
+	keys  *middleware.Keyring
+	roles *perm.Store
+	sched *scheduler.Scheduler
+	live  *live.Broker
 }
 
-func New(db *sql.DB, cfg *config.Config) *Handler {
-	return &Handler{db: db, cfg: cfg}
+// New takes the keyring and role store that middleware.JWTAuth uses, so
+// tokens are signed with the same keys and role edits reach it at once.
+func New(db *sql.DB, cfg *config.Config, keys *middleware.Keyring, roles *perm.Store) *Handler {
+	h := &Handler{db: db, cfg: cfg, keys: keys, roles: roles, live: live.NewBroker(500)}
+	h.sched = scheduler.New(db, cfg.ReportDir, h.WriteReport)
+	return h
 }
 
 // ==================== Pages ====================
@@ -105,53 +117,98 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	ip := h.loginIP(c)
+	if d := h.loginLockedFor(req.Username, ip); d > 0 {
+		metrics.Logins.Inc("locked")
+		h.auditAs(c, 0, req.Username, "login.locked", "user", nil, nil, nil)
+		rejectLocked(c, d)
+		return
+	}
+	lock, wait, ipLocked := h.countLoginAttempt(req.Username, ip)
+	if wait > 0 {
+		// A concurrent attempt set a lock since the check above.
+		metrics.Logins.Inc("locked")
+		h.auditAs(c, 0, req.Username, "login.locked", "user", nil, nil, nil)
+		rejectLocked(c, wait)
+		return
+	}
+
 	var user model.User
 	err := h.db.QueryRow(
-		"SELECT id, username, password_hash, display_name, role FROM users WHERE username = ?",
+		"SELECT id, username, password_hash, display_name, role, department, building, must_change_password FROM users WHERE username = ?",
 		req.Username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName, &user.Role)
-
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
-		return
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.DisplayName, &user.Role, &user.Department, &user.Building, &user.MustChange)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	if err != nil {
+		metrics.Logins.Inc("failure")
+		h.auditAs(c, 0, req.Username, "login.failure", "user", nil, nil, gin.H{"locked": lock > 0})
+		if lock > 0 {
+			rejectLocked(c, lock)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
+	metrics.Logins.Inc("success")
+	h.clearLoginFailures(req.Username, ip, ipLocked)
+	h.auditAs(c, user.ID, user.Username, "login.success", "user", user.ID, nil, nil)
 
-	token, err := middleware.GenerateToken(h.cfg.JWTSecret, user)
+	token, refresh, err := h.startSession(c, user)
 	if err != nil {
+		log.Printf("Start session error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
 		return
 	}
 
-	c.SetCookie("token", token, 86400, "/", "", false, true)
+	h.setAuthCookies(c, token, refresh)
 	c.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":         token,
+		"refresh_token": refresh,
+		"expires_in":    int(h.accessTTL() / time.Second),
 		"user": gin.H{
 			"id":           user.ID,
 			"username":     user.Username,
 			"display_name": user.DisplayName,
 			"role":         user.Role,
+			"department":   user.Department,
+			"building":     user.Building,
+			"permissions":  h.roles.Permissions(user.Role).List(),
 		},
+		"must_change_password": user.MustChange,
 	})
 }
 
 func (h *Handler) Logout(c *gin.Context) {
-	c.SetCookie("token", "", -1, "/", "", false, true)
+	if v, ok := c.Get("user"); ok {
+		h.revokeSessionJTI(v.(model.Claims).SessionID, "logout")
+		h.audit(c, "logout", "", nil, nil, nil)
+	}
+	clearAuthCookies(c)
 	c.JSON(http.StatusOK, gin.H{"message": "已注销"})
 }
 
 func (h *Handler) GetCurrentUser(c *gin.Context) {
 	user := getUser(c)
+	var must bool
+	err := h.db.QueryRow("SELECT must_change_password FROM users WHERE id = ?", user.UserID).Scan(&must)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Load must_change_password error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"user": gin.H{
-			"id":       user.UserID,
-			"username": user.Username,
-			"role":     user.Role,
+			"id":          user.UserID,
+			"username":    user.Username,
+			"role":        user.Role,
+			"department":  user.Department,
+			"building":    user.Building,
+			"classes":     user.Classes,
+			"permissions": user.Perms.List(),
 		},
+		"must_change_password": must,
 	})
 }
 
@@ -166,6 +223,18 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 		return
 	}
 
+	if !h.categoryExists(req.Category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "违纪类别不存在"})
+		return
+	}
+	// A scoped create permission limits what can be entered, e.g. a dorm
+	// supervisor only records for rooms in their building.
+	draft := model.Violation{Dorm: req.Dorm, ClassName: req.ClassName, Department: req.Department, CreatedBy: user.UserID}
+	if scope := scopeFor(c, perm.ViolationCreate); !scope.allows(&draft) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+
 	// Handle photo upload
 	photoPath := ""
 	file, header, err := c.Request.FormFile("photo")
@@ -174,6 +243,7 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 
 		// Validate file size
 		if header.Size > h.cfg.MaxUpload {
+			metrics.UploadFailures.Inc("too_large")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "照片大小不能超过 5MB"})
 			return
 		}
@@ -182,6 +252,7 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 		ext := strings.ToLower(filepath.Ext(header.Filename))
 		allowed := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
 		if !allowed[ext] {
+			metrics.UploadFailures.Inc("bad_extension")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "仅支持 JPG/PNG/GIF/WebP 格式的图片"})
 			return
 		}
@@ -191,6 +262,7 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 		n, _ := file.Read(buf)
 		mimeType := http.DetectContentType(buf[:n])
 		if !strings.HasPrefix(mimeType, "image/") {
+			metrics.UploadFailures.Inc("bad_content")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "文件类型不合法"})
 			return
 		}
@@ -204,23 +276,27 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 
 		dst, err := os.Create(savePath)
 		if err != nil {
+			metrics.UploadFailures.Inc("save_error")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
 			return
 		}
 		defer dst.Close()
 
-		if _, err := io.Copy(dst, file); err != nil {
+		written, err := io.Copy(dst, file)
+		if err != nil {
+			metrics.UploadFailures.Inc("save_error")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "文件保存失败"})
 			return
 		}
 
+		metrics.UploadBytes.Observe(float64(written))
 		photoPath = filename
 	}
 
 	result, err := h.db.Exec(
-		`INSERT INTO violations (dorm, student_name, class_name, period, reason, department, inspector, photo_path, created_by)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		req.Dorm, req.StudentName, req.ClassName, req.Period, req.Reason, req.Department, req.Inspector, photoPath, user.UserID,
+		`INSERT INTO violations (dorm, student_name, class_name, period, reason, department, category, inspector, photo_path, created_by)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Dorm, req.StudentName, req.ClassName, req.Period, req.Reason, req.Department, req.Category, req.Inspector, photoPath, user.UserID,
 	)
 	if err != nil {
 		log.Printf("Insert violation error: %v", err)
@@ -229,6 +305,8 @@ func (h *Handler) CreateViolation(c *gin.Context) {
 	}
 
 	id, _ := result.LastInsertId()
+	h.audit(c, "violation.create", "violation", id, nil, gin.H{"request": req, "photo_path": photoPath})
+	h.publish(live.Created, uint(id))
 	c.JSON(http.StatusOK, gin.H{"id": id, "message": "提交成功"})
 }
 
@@ -246,8 +324,12 @@ func (h *Handler) ListViolations(c *gin.Context) {
 	}
 	offset := (page - 1) * limit
 
-	where := "WHERE 1=1"
-	args := []interface{}{}
+	scope, ok := requireScope(c, perm.ViolationRead)
+	if !ok {
+		return
+	}
+	where, args := scope.where()
+	where = "WHERE 1=1" + where
 
 	if dateStr != "" {
 		where += " AND DATE(v.created_at) = ?"
@@ -268,7 +350,7 @@ func (h *Handler) ListViolations(c *gin.Context) {
 	// Query data
 	querySQL := fmt.Sprintf(`
 		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason, 
-		       v.department, v.inspector, v.photo_path, v.created_by, v.created_at,
+		       v.department, v.category, v.inspector, v.photo_path, v.created_by, v.created_at,
 		       COALESCE(u.display_name, u.username) as creator_name
 		FROM violations v
 		LEFT JOIN users u ON v.created_by = u.id
@@ -290,7 +372,7 @@ func (h *Handler) ListViolations(c *gin.Context) {
 	for rows.Next() {
 		var v model.Violation
 		err := rows.Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
-			&v.Department, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName)
+			&v.Department, &v.Category, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName)
 		if err != nil {
 			continue
 		}
@@ -305,39 +387,107 @@ func (h *Handler) ListViolations(c *gin.Context) {
 	})
 }
 
+// GetTodayViolations backs the public screen, so records are projected and
+// masked (see publicView); the audit page uses ListViolations instead.
 func (h *Handler) GetTodayViolations(c *gin.Context) {
+	scope, ok := h.publicScope(c)
+	if !ok {
+		return
+	}
+	scopeSQL, scopeArgs := scope.where()
 	today := time.Now().Format("2006-01-02")
+	// Taken before the query: the stream resumes from here, so a change
+	// racing with the query is delivered again rather than lost.
+	lastEventID := h.live.LastID()
 
 	rows, err := h.db.Query(`
 		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
-		       v.department, v.inspector, v.photo_path, v.created_by, v.created_at,
+		       v.department, v.category, v.inspector, v.photo_path, v.created_by, v.created_at,
 		       COALESCE(u.display_name, u.username) as creator_name
 		FROM violations v
 		LEFT JOIN users u ON v.created_by = u.id
-		WHERE DATE(v.created_at) = ?
+		WHERE DATE(v.created_at) = ?`+scopeSQL+`
 		ORDER BY v.created_at DESC
-	`, today)
+	`, append([]interface{}{today}, scopeArgs...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 		return
 	}
 	defer rows.Close()
 
-	violations := []model.Violation{}
+	violations := []model.PublicViolation{}
 	for rows.Next() {
 		var v model.Violation
 		rows.Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
-			&v.Department, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName)
-		violations = append(violations, v)
+			&v.Department, &v.Category, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName)
+		violations = append(violations, publicView(v, scope.Mask))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"data":  violations,
-		"date":  today,
-		"count": len(violations),
+		"data":          violations,
+		"date":          today,
+		"count":         len(violations),
+		"last_event_id": lastEventID,
+		"mask":          scope.Mask,
 	})
 }
 
+// UpdateViolation corrects the text fields of a record within the user's
+// violation:update scope. The photo is left unchanged.
+func (h *Handler) UpdateViolation(c *gin.Context) {
+	idNum, err := strconv.Atoi(c.Param("id"))
+	if err != nil || idNum < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录 ID"})
+		return
+	}
+
+	var req model.ViolationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请填写完整信息: " + err.Error()})
+		return
+	}
+	if !h.categoryExists(req.Category) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "违纪类别不存在"})
+		return
+	}
+
+	before, err := h.getViolation(uint(idNum))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	// The record has to be in scope both before and after the edit, so a
+	// department-scoped user cannot hand it to another department. A
+	// scoped create permission applies to the result too, so a dorm
+	// supervisor cannot move a record to another building.
+	scope := scopeFor(c, perm.ViolationUpdate)
+	after := *before
+	after.Dorm, after.ClassName, after.Department = req.Dorm, req.ClassName, req.Department
+	if !scope.allows(before) || !scope.allows(&after) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+	if create := scopeFor(c, perm.ViolationCreate); create.level != perm.Any && create.level != perm.None && !create.allows(&after) {
+		c.JSON(http.StatusForbidden, gin.H{"error": create.denyMessage()})
+		return
+	}
+
+	_, err = h.db.Exec(
+		`UPDATE violations SET dorm = ?, student_name = ?, class_name = ?, period = ?, reason = ?,
+		        department = ?, category = ?, inspector = ?, revision_count = revision_count + 1, updated_at = NOW()
+		 WHERE id = ?`,
+		req.Dorm, req.StudentName, req.ClassName, req.Period, req.Reason, req.Department, req.Category, req.Inspector, idNum,
+	)
+	if err != nil {
+		log.Printf("Update violation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	h.audit(c, "violation.update", "violation", idNum, before, req)
+	h.publish(live.Updated, uint(idNum))
+	c.JSON(http.StatusOK, gin.H{"message": "修改成功"})
+}
+
 func (h *Handler) DeleteViolation(c *gin.Context) {
 	id := c.Param("id")
 	idNum, err := strconv.Atoi(id)
@@ -346,22 +496,56 @@ func (h *Handler) DeleteViolation(c *gin.Context) {
 		return
 	}
 
-	// Get photo path before deletion
-	var photoPath string
-	h.db.QueryRow("SELECT photo_path FROM violations WHERE id = ?", idNum).Scan(&photoPath)
-
-	result, err := h.db.Exec("DELETE FROM violations WHERE id = ?", idNum)
+	user := getUser(c)
+	before, err := h.getViolation(uint(idNum))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	if scope := scopeFor(c, perm.ViolationDelete); !scope.allows(before) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+	tx, err := h.db.Begin()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
 		return
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
+	// Get photo path and owner before deletion
+	var photoPath, inspector string
+	var createdBy uint
+	var createdAt time.Time
+	err = tx.QueryRow("SELECT photo_path, created_by, inspector, created_at FROM violations WHERE id = ? FOR UPDATE", idNum).
+		Scan(&photoPath, &createdBy, &inspector, &createdAt)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
 		return
 	}
 
+	if _, err := tx.Exec("DELETE FROM violations WHERE id = ?", idNum); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+
+	// Keep a tombstone so workload statistics can count deletions
+	_, err = tx.Exec(
+		`INSERT INTO violation_deletions (violation_id, created_by, inspector, created_at, deleted_by)
+		 VALUES (?, ?, ?, ?, ?)`,
+		idNum, createdBy, inspector, createdAt, user.UserID,
+	)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+
+	h.audit(c, "violation.delete", "violation", idNum, before, nil)
+	h.live.Publish(live.Deleted, uint(idNum), nil)
+
 	// Delete photo file
 	if photoPath != "" {
 		os.Remove(filepath.Join(h.cfg.UploadDir, photoPath))
@@ -378,12 +562,16 @@ func (h *Handler) GetViolationPhoto(c *gin.Context) {
 		return
 	}
 
-	var photoPath string
-	err = h.db.QueryRow("SELECT photo_path FROM violations WHERE id = ?", idNum).Scan(&photoPath)
-	if err != nil || photoPath == "" {
+	v, err := h.getViolation(uint(idNum))
+	if err != nil || v.PhotoPath == "" {
 		c.JSON(http.StatusNotFound, gin.H{"error": "照片不存在"})
 		return
 	}
+	if scope := scopeFor(c, perm.ViolationRead); !scope.allows(v) {
+		c.JSON(http.StatusForbidden, gin.H{"error": scope.denyMessage()})
+		return
+	}
+	photoPath := v.PhotoPath
 
 	fullPath := filepath.Join(h.cfg.UploadDir, photoPath)
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
@@ -394,58 +582,10 @@ func (h *Handler) GetViolationPhoto(c *gin.Context) {
 	c.File(fullPath)
 }
 
-// ==================== Export API ====================
-
-func (h *Handler) ExportCSV(c *gin.Context) {
-	dateStr := c.Query("date")
-	if dateStr == "" {
-		dateStr = time.Now().Format("2006-01-02")
-	}
-
-	rows, err := h.db.Query(`
-		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
-		       v.department, v.inspector, v.created_at,
-		       COALESCE(u.display_name, u.username) as creator_name
-		FROM violations v
-		LEFT JOIN users u ON v.created_by = u.id
-		WHERE DATE(v.created_at) = ?
-		ORDER BY v.created_at ASC
-	`, dateStr)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
-		return
-	}
-	defer rows.Close()
-
-	// BOM for Excel UTF-8 compatibility
-	bom := "\xEF\xBB\xBF"
-	csv := bom + "ID,宿舍号,姓名,班级,时间段,违纪原因,部门,执勤人,记录时间,录入人\n"
-
-	for rows.Next() {
-		var id uint
-		var dorm, name, class, period, reason, dept, inspector, creator string
-		var createdAt time.Time
-		rows.Scan(&id, &dorm, &name, &class, &period, &reason, &dept, &inspector, &createdAt, &creator)
-
-		// Escape CSV fields
-		reason = strings.ReplaceAll(reason, "\"", "\"\"")
-		reason = strings.ReplaceAll(reason, "\n", " ")
-
-		csv += fmt.Sprintf("%d,\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\"\n",
-			id, dorm, name, class, period, reason, dept, inspector,
-			createdAt.Format("2006-01-02 15:04:05"), creator)
-	}
-
-	filename := fmt.Sprintf("violations_%s.csv", dateStr)
-	c.Header("Content-Type", "text/csv; charset=utf-8")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.String(http.StatusOK, csv)
-}
-
 // ==================== User Management (Admin) ====================
 
 func (h *Handler) ListUsers(c *gin.Context) {
-	rows, err := h.db.Query("SELECT id, username, display_name, role, created_at FROM users ORDER BY id")
+	rows, err := h.db.Query("SELECT id, username, display_name, role, department, building, must_change_password, created_at FROM users ORDER BY id")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
 		return
@@ -453,25 +593,67 @@ func (h *Handler) ListUsers(c *gin.Context) {
 	defer rows.Close()
 
 	users := []model.User{}
+	index := map[uint]int{}
 	for rows.Next() {
 		var u model.User
-		rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Role, &u.CreatedAt)
+		rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.Role, &u.Department, &u.Building, &u.MustChange, &u.CreatedAt)
+		index[u.ID] = len(users)
 		users = append(users, u)
 	}
+	rows.Close()
+
+	links, err := h.db.Query("SELECT user_id, class_name FROM user_classes ORDER BY class_name")
+	if err == nil {
+		defer links.Close()
+		for links.Next() {
+			var id uint
+			var class string
+			if links.Scan(&id, &class) == nil {
+				if i, ok := index[id]; ok {
+					users[i].Classes = append(users[i].Classes, class)
+				}
+			}
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{"data": users})
 }
 
 func (h *Handler) CreateUser(c *gin.Context) {
 	var body struct {
-		Username    string `json:"username" binding:"required"`
-		Password    string `json:"password" binding:"required,min=6"`
-		DisplayName string `json:"display_name"`
-		Role        string `json:"role" binding:"required,oneof=admin staff"`
+		Username    string   `json:"username" binding:"required"`
+		Password    string   `json:"password" binding:"required"`
+		DisplayName string   `json:"display_name"`
+		Role        string   `json:"role" binding:"required"`
+		Department  string   `json:"department" binding:"max=30"`
+		Building    string   `json:"building" binding:"max=20"`
+		Classes     []string `json:"classes"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
 		return
 	}
+	if !h.roleExists(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色不存在"})
+		return
+	}
+	if !h.mayGrant(c, body.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能分配比自己权限更大的角色"})
+		return
+	}
+	body.Department = strings.TrimSpace(body.Department)
+	building, ok := bindBuilding(c, body.Building)
+	if !ok {
+		return
+	}
+	body.Building = building
+	classes, ok := h.bindUserClasses(c, body.Classes)
+	if !ok {
+		return
+	}
+	if err := h.checkPassword(0, body.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -483,9 +665,9 @@ func (h *Handler) CreateUser(c *gin.Context) {
 		body.DisplayName = body.Username
 	}
 
-	_, err = h.db.Exec(
-		"INSERT INTO users (username, password_hash, display_name, role) VALUES (?, ?, ?, ?)",
-		body.Username, string(hash), body.DisplayName, body.Role,
+	result, err := h.db.Exec(
+		"INSERT INTO users (username, password_hash, display_name, role, department, building, must_change_password) VALUES (?, ?, ?, ?, ?, ?, 1)",
+		body.Username, string(hash), body.DisplayName, body.Role, body.Department, body.Building,
 	)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate") {
@@ -496,6 +678,15 @@ func (h *Handler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	id, _ := result.LastInsertId()
+	if err := h.setUserClasses(uint(id), classes); err != nil {
+		log.Printf("Set classes of user %d error: %v", id, err)
+	}
+	if body.Building != "" {
+		h.ensureBuildingReports()
+	}
+	after, _ := h.userSnapshot(int(id))
+	h.audit(c, "user.create", "user", id, nil, after)
 	c.JSON(http.StatusOK, gin.H{"message": "用户创建成功"})
 }
 
@@ -514,41 +705,158 @@ func (h *Handler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	_, err := h.db.Exec("DELETE FROM users WHERE id = ?", idNum)
+	before, err := h.userSnapshot(idNum)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !h.mayGrant(c, before["role"].(string)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能操作权限比自己大的用户"})
+		return
+	}
+	// Sessions go with the user (ON DELETE CASCADE), which logs them out.
+	_, err = h.db.Exec("DELETE FROM users WHERE id = ?", idNum)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
 		return
 	}
+	h.audit(c, "user.delete", "user", idNum, before, nil)
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
+// UpdateUser changes a user's display name, role, department, building
+// and linked classes. A role change also logs the user out everywhere.
+func (h *Handler) UpdateUser(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var body struct {
+		DisplayName string   `json:"display_name"`
+		Role        string   `json:"role" binding:"required"`
+		Department  string   `json:"department" binding:"max=30"`
+		Building    string   `json:"building" binding:"max=20"`
+		Classes     []string `json:"classes"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+	if !h.roleExists(body.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色不存在"})
+		return
+	}
+	if !h.mayGrant(c, body.Role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能分配比自己权限更大的角色"})
+		return
+	}
+	classes, ok := h.bindUserClasses(c, body.Classes)
+	if !ok {
+		return
+	}
+	if uint(id) == getUser(c).UserID && !h.roles.Permissions(body.Role).Has(perm.UserManage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能取消自己的用户管理权限"})
+		return
+	}
+
+	before, err := h.userSnapshot(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !h.mayGrant(c, before["role"].(string)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能操作权限比自己大的用户"})
+		return
+	}
+	body.DisplayName = strings.TrimSpace(body.DisplayName)
+	if body.DisplayName == "" {
+		body.DisplayName = before["display_name"].(string)
+	}
+	body.Department = strings.TrimSpace(body.Department)
+	if body.Building, ok = bindBuilding(c, body.Building); !ok {
+		return
+	}
+	if _, err := h.db.Exec("UPDATE users SET display_name = ?, role = ?, department = ?, building = ? WHERE id = ?",
+		body.DisplayName, body.Role, body.Department, body.Building, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if err := h.setUserClasses(uint(id), classes); err != nil {
+		log.Printf("Set classes of user %d error: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if before["role"] != body.Role {
+		h.revokeUserSessions(uint(id), "", "role_change")
+	}
+	if body.Building != "" {
+		h.ensureBuildingReports()
+	}
+	after, _ := h.userSnapshot(id)
+	h.audit(c, "user.update", "user", id, before, after)
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+// ResetPassword sets a password chosen by an admin. The user has to
+// replace it at their next login.
 func (h *Handler) ResetPassword(c *gin.Context) {
 	id := c.Param("id")
+	target, _ := strconv.Atoi(id)
+	if target < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
 	var body struct {
-		Password string `json:"password" binding:"required,min=6"`
+		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "密码至少6位"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入新密码"})
 		return
 	}
-
-	hash, _ := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	_, err := h.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), id)
+	// Resetting someone's password takes over their account, so it needs
+	// at least their permissions.
+	before, err := h.userSnapshot(target)
 	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if !h.mayGrant(c, before["role"].(string)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能操作权限比自己大的用户"})
+		return
+	}
+	if err := h.checkPassword(uint(target), body.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.setPassword(uint(target), body.Password, true); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+			return
+		}
+		log.Printf("Reset password error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置失败"})
 		return
 	}
+	h.revokeUserSessions(uint(target), "", "password_reset")
+	// The password itself is never logged, only whose it was.
+	after, _ := h.userSnapshot(target)
+	h.audit(c, "user.password_reset", "user", id, nil, after)
 	c.JSON(http.StatusOK, gin.H{"message": "密码重置成功"})
 }
 
 // ==================== Stats ====================
 
+// GetStats counts the records the user can read.
 func (h *Handler) GetStats(c *gin.Context) {
 	today := time.Now().Format("2006-01-02")
+	cond, args := scopeFor(c, perm.ViolationRead).where()
 
 	var todayCount, totalCount, userCount int
-	h.db.QueryRow("SELECT COUNT(*) FROM violations WHERE DATE(created_at) = ?", today).Scan(&todayCount)
-	h.db.QueryRow("SELECT COUNT(*) FROM violations").Scan(&totalCount)
+	h.db.QueryRow("SELECT COUNT(*) FROM violations v WHERE DATE(v.created_at) = ?"+cond,
+		append([]interface{}{today}, args...)...).Scan(&todayCount)
+	h.db.QueryRow("SELECT COUNT(*) FROM violations v WHERE 1=1"+cond, args...).Scan(&totalCount)
 	h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount)
 
 	c.JSON(http.StatusOK, gin.H{
@@ -560,23 +868,30 @@ func (h *Handler) GetStats(c *gin.Context) {
 
 // ==================== Seed default admin ====================
 
+const defaultAdminPassword = "admin123"
+
+// SeedAdmin creates admin / admin123 on an empty database. The password
+// has to be changed at first login.
 func (h *Handler) SeedAdmin() {
+	h.seedRoles()
+
 	var count int
 	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'admin'").Scan(&count)
 	if count > 0 {
+		h.flagDefaultAdmin()
 		return
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte("admin123"), bcrypt.DefaultCost)
+	hash, _ := bcrypt.GenerateFromPassword([]byte(defaultAdminPassword), bcrypt.DefaultCost)
 	_, err := h.db.Exec(
-		"INSERT INTO users (username, password_hash, display_name, role) VALUES (?, ?, ?, ?)",
+		"INSERT INTO users (username, password_hash, display_name, role, must_change_password) VALUES (?, ?, ?, ?, 1)",
 		"admin", string(hash), "系统管理员", "admin",
 	)
 	if err != nil {
 		log.Printf("Seed admin error: %v", err)
 		return
 	}
-	log.Println("Default admin created: admin / admin123")
+	log.Println("Default admin created: admin / " + defaultAdminPassword + " (must be changed at first login)")
 }
 
 // ==================== Template Functions ====================
@@ -596,6 +911,108 @@ func getUser(c *gin.Context) model.Claims {
 	return user.(model.Claims)
 }
 
+func (h *Handler) getViolation(id uint) (*model.Violation, error) {
+	var v model.Violation
+	err := h.db.QueryRow(`
+		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
+		       v.department, v.category, v.inspector, v.photo_path, v.created_by, v.created_at,
+		       COALESCE(u.display_name, u.username) as creator_name
+		FROM violations v
+		LEFT JOIN users u ON v.created_by = u.id
+		WHERE v.id = ?
+	`, id).Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
+		&v.Department, &v.Category, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt, &v.CreatorName)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// userSnapshot is what the audit log keeps of an account.
+func (h *Handler) userSnapshot(id int) (gin.H, error) {
+	var username, displayName, role, department, building string
+	err := h.db.QueryRow("SELECT username, display_name, role, department, building FROM users WHERE id = ?", id).
+		Scan(&username, &displayName, &role, &department, &building)
+	if err != nil {
+		return nil, err
+	}
+	classes := []string{}
+	rows, err := h.db.Query("SELECT class_name FROM user_classes WHERE user_id = ? ORDER BY class_name", id)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil {
+				classes = append(classes, name)
+			}
+		}
+	}
+	return gin.H{"id": id, "username": username, "display_name": displayName, "role": role,
+		"department": department, "building": building, "classes": classes}, nil
+}
+
+// bindBuilding checks the building of an account: the part of a dorm
+// number before the dash, e.g. "3" for 3-301.
+func bindBuilding(c *gin.Context, building string) (string, bool) {
+	building = strings.TrimSpace(building)
+	if strings.Contains(building, "-") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "楼号填写宿舍号“-”之前的部分，如 3-301 填 3"})
+		return "", false
+	}
+	return building, true
+}
+
+// bindUserClasses trims and dedupes the classes linked to an account and
+// checks that each is in the classes table.
+func (h *Handler) bindUserClasses(c *gin.Context, names []string) ([]string, bool) {
+	seen := map[string]bool{}
+	classes := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		var n int
+		h.db.QueryRow("SELECT COUNT(*) FROM classes WHERE name = ?", name).Scan(&n)
+		if n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "班级不存在: " + name})
+			return nil, false
+		}
+		seen[name] = true
+		classes = append(classes, name)
+	}
+	return classes, true
+}
+
+// setUserClasses replaces the classes linked to a user.
+func (h *Handler) setUserClasses(userID uint, classes []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM user_classes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, name := range classes {
+		if _, err := tx.Exec("INSERT INTO user_classes (user_id, class_name) VALUES (?, ?)", userID, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// categoryExists accepts an empty category (uncategorised) or one defined
+// in the categories table.
+func (h *Handler) categoryExists(name string) bool {
+	if name == "" {
+		return true
+	}
+	var n int
+	h.db.QueryRow("SELECT COUNT(*) FROM categories WHERE name = ?", name).Scan(&n)
+	return n > 0
+}
+
 func getCSRF(c *gin.Context) string {
 	token, _ := c.Get("csrf_token")
 	if token == nil {
@@ -0,0 +1,194 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/live"
+	"suv/internal/model"
+	"suv/internal/report"
+)
+
+const liveHeartbeat = 25 * time.Second
+
+// StreamToday pushes changes to today's records as Server-Sent Events:
+// "created" and "updated" carry the record, "deleted" only its id. A
+// "reset" event means the client missed events (server restart, buffer
+// overrun, new day) and must reload /api/violations/today.
+//
+// Clients resume with the Last-Event-ID header, which browsers send on
+// reconnect, or ?last_event_id= taken from the JSON list on first connect.
+// Records are masked and scoped the same way as the list.
+func (h *Handler) StreamToday(c *gin.Context) {
+	scope, ok := h.publicScope(c)
+	if !ok {
+		return
+	}
+	inScope, err := h.scopeMatcher(scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+	ch, replay, resumed := h.live.Subscribe(lastID)
+	defer h.live.Unsubscribe(ch)
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+
+	io.WriteString(w, "retry: 5000\n\n")
+	today := report.Day(time.Now())
+	if !resumed {
+		writeSSE(w, h.live.LastID(), "reset", gin.H{})
+	}
+	for _, e := range replay {
+		if onDay(e, today) && inScope(e) {
+			writeSSE(w, e.ID, e.Type, publicEvent(e, scope.Mask))
+		}
+	}
+	w.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	midnight := time.NewTimer(time.Until(today.AddDate(0, 0, 1)))
+	defer midnight.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, open := <-ch:
+			if !open {
+				// Dropped as too slow, or shutting down; the client
+				// reconnects and resumes from its last id.
+				return
+			}
+			if onDay(e, today) && inScope(e) {
+				if err := writeSSE(w, e.ID, e.Type, publicEvent(e, scope.Mask)); err != nil {
+					return
+				}
+			}
+		case <-heartbeat.C:
+			if scope.ID != 0 && !h.displayActive(scope.ID) {
+				// Revoked while connected; the reconnect gets a 401.
+				return
+			}
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-midnight.C:
+			today = report.Day(time.Now())
+			midnight.Reset(time.Until(today.AddDate(0, 0, 1)))
+			writeSSE(w, h.live.LastID(), "reset", gin.H{"date": today.Format("2006-01-02")})
+		}
+		w.Flush()
+	}
+}
+
+// publish sends the current state of a record to the display streams.
+func (h *Handler) publish(typ string, id uint) {
+	v, err := h.getViolation(id)
+	if err != nil {
+		log.Printf("Live publish error: %v", err)
+		return
+	}
+	h.live.Publish(typ, id, v)
+}
+
+type liveEvent struct {
+	Type      string                 `json:"type"`
+	ID        uint                   `json:"id"`
+	Violation *model.PublicViolation `json:"violation,omitempty"`
+}
+
+func publicEvent(e live.Event, mask string) liveEvent {
+	out := liveEvent{Type: e.Type, ID: e.ViolationID}
+	if e.Violation != nil {
+		p := publicView(*e.Violation, mask)
+		out.Violation = &p
+	}
+	return out
+}
+
+// scopeMatcher returns a filter for events outside a display's building or
+// grade. The grade's class list is read once per connection.
+func (h *Handler) scopeMatcher(s displayScope) (func(live.Event) bool, error) {
+	var classes map[string]bool
+	if s.Grade != "" {
+		rows, err := h.db.Query("SELECT name FROM classes WHERE grade = ?", s.Grade)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		classes = map[string]bool{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, err
+			}
+			classes[name] = true
+		}
+	}
+	return func(e live.Event) bool {
+		v := e.Violation
+		if v == nil {
+			return true
+		}
+		if s.Building != "" {
+			building, _, found := strings.Cut(v.Dorm, "-")
+			if !found || building != s.Building {
+				return false
+			}
+		}
+		return classes == nil || classes[v.ClassName]
+	}, nil
+}
+
+func (h *Handler) displayActive(id uint) bool {
+	var n int
+	h.db.QueryRow("SELECT COUNT(*) FROM displays WHERE id = ? AND revoked_at IS NULL", id).Scan(&n)
+	return n > 0
+}
+
+// onDay reports whether a change concerns the given day's list. Deletions
+// carry no record, so they always go out; clients ignore unknown ids.
+func onDay(e live.Event, day time.Time) bool {
+	return e.Violation == nil || report.Day(e.Violation.CreatedAt).Equal(day)
+}
+
+func writeSSE(w io.Writer, id, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, b)
+	return err
+}
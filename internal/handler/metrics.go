@@ -0,0 +1,119 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/metrics"
+	"suv/internal/report"
+)
+
+// Metrics serves Prometheus metrics. It is off unless METRICS_TOKEN is set,
+// and the scraper must send the token as "Authorization: Bearer <token>".
+// It is not accepted in the URL, which ends up in access logs.
+func (h *Handler) Metrics(c *gin.Context) {
+	if h.cfg.MetricsToken == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.MetricsToken)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效凭证"})
+		return
+	}
+
+	gauges := h.dbGauges()
+	biz, err := h.businessGauges()
+	if err != nil {
+		// Still serve the in-process metrics; the scrape should not fail
+		// because the database is down, that is exactly when it matters.
+		log.Printf("Metrics query error: %v", err)
+	}
+	gauges = append(gauges, biz...)
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Write(c.Writer, gauges...)
+}
+
+func (h *Handler) dbGauges() []metrics.Gauge {
+	s := h.db.Stats()
+	g := func(name, help string, v float64) metrics.Gauge {
+		return metrics.Gauge{Name: name, Help: help, Samples: []metrics.Sample{{Value: v}}}
+	}
+	return []metrics.Gauge{
+		g("suv_db_open_connections", "Open database connections.", float64(s.OpenConnections)),
+		g("suv_db_in_use_connections", "Database connections in use.", float64(s.InUse)),
+		g("suv_db_idle_connections", "Idle database connections.", float64(s.Idle)),
+		g("suv_db_max_open_connections", "Configured connection limit (0 = unlimited).", float64(s.MaxOpenConnections)),
+		g("suv_db_wait_count", "Connections waited for since start.", float64(s.WaitCount)),
+		g("suv_db_wait_duration_seconds", "Time spent waiting for a connection since start.", s.WaitDuration.Seconds()),
+		g("suv_db_max_idle_closed", "Connections closed because of the idle limit since start.", float64(s.MaxIdleClosed)),
+		g("suv_db_max_lifetime_closed", "Connections closed because of the lifetime limit since start.", float64(s.MaxLifetimeClosed)),
+	}
+}
+
+func (h *Handler) businessGauges() ([]metrics.Gauge, error) {
+	start := report.Day(time.Now())
+	end := start.AddDate(0, 0, 1)
+
+	today := metrics.Gauge{Name: "suv_violations_today", Help: "Violations recorded today by period."}
+	rows, err := h.db.Query(
+		"SELECT period, COUNT(*) FROM violations WHERE created_at >= ? AND created_at < ? GROUP BY period",
+		start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var period string
+		var n int
+		if err := rows.Scan(&period, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		today.Samples = append(today.Samples, metrics.Sample{Labels: []string{"period", period}, Value: float64(n)})
+	}
+	rows.Close()
+
+	var photos, stored, users int
+	if err := h.db.QueryRow(
+		"SELECT COUNT(*) FROM violations WHERE created_at >= ? AND created_at < ? AND photo_path <> ''",
+		start, end).Scan(&photos); err != nil {
+		return nil, err
+	}
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM violations").Scan(&stored); err != nil {
+		return nil, err
+	}
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		return nil, err
+	}
+
+	one := func(name, help string, v int) metrics.Gauge {
+		return metrics.Gauge{Name: name, Help: help, Samples: []metrics.Sample{{Value: float64(v)}}}
+	}
+	return []metrics.Gauge{
+		today,
+		one("suv_violations_today_with_photo", "Violations recorded today with a photo.", photos),
+		one("suv_violations_stored", "Violations currently stored.", stored),
+		one("suv_users", "User accounts.", users),
+	}, nil
+}
@@ -0,0 +1,291 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+	"suv/internal/report"
+)
+
+// pointsExpr is the deduction for one violation: its category's points, or
+// 1 for uncategorised records. Queries using it must join categories c.
+const pointsExpr = "COALESCE(c.points, 1)"
+
+const offenderFrom = ` FROM violations v
+	LEFT JOIN users u ON v.created_by = u.id
+	LEFT JOIN categories c ON c.name = v.category `
+
+type offender struct {
+	StudentName string    `json:"student_name"`
+	ClassName   string    `json:"class_name"`
+	Count       int       `json:"count"`
+	Points      int       `json:"points"`
+	LastAt      time.Time `json:"last_at"`
+	Watched     bool      `json:"watched"`
+}
+
+type dormOffender struct {
+	Dorm     string    `json:"dorm"`
+	Count    int       `json:"count"`
+	Points   int       `json:"points"`
+	Students int       `json:"students"`
+	LastAt   time.Time `json:"last_at"`
+}
+
+// GetOffenders ranks students (by=student, the default) or dorm rooms
+// (by=dorm) by number of violations and points over a date range. The
+// statistics filters (class=, department=, ...) apply as well.
+func (h *Handler) GetOffenders(c *gin.Context) {
+	where, args, start, end, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	order := "n DESC, p DESC"
+	if c.Query("sort") == "points" {
+		order = "p DESC, n DESC"
+	}
+
+	resp := gin.H{
+		"start": start.Format("2006-01-02"),
+		"end":   end.AddDate(0, 0, -1).Format("2006-01-02"),
+	}
+
+	if c.DefaultQuery("by", "student") == "dorm" {
+		rows, err := h.db.Query(
+			"SELECT v.dorm, COUNT(*) AS n, SUM("+pointsExpr+") AS p, "+
+				"COUNT(DISTINCT v.student_name, v.class_name), MAX(v.created_at)"+
+				offenderFrom+where+" AND v.dorm <> '' GROUP BY v.dorm ORDER BY "+order+" LIMIT ?",
+			append(args, limit)...)
+		if err != nil {
+			log.Printf("Dorm offenders error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		defer rows.Close()
+
+		list := []dormOffender{}
+		for rows.Next() {
+			var d dormOffender
+			if err := rows.Scan(&d.Dorm, &d.Count, &d.Points, &d.Students, &d.LastAt); err != nil {
+				continue
+			}
+			list = append(list, d)
+		}
+		resp["by"] = "dorm"
+		resp["data"] = list
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	list, err := h.queryOffenders(where, "", order, args, limit)
+	if err == nil {
+		err = h.markWatched(list, scopeFor(c, perm.ViolationRead))
+	}
+	if err != nil {
+		log.Printf("Offenders error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	resp["by"] = "student"
+	resp["data"] = list
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetWatchList returns the students the user can see who crossed the
+// configured threshold within the watch window ending today.
+func (h *Handler) GetWatchList(c *gin.Context) {
+	start, end := h.watchWindow()
+
+	having := fmt.Sprintf("HAVING n >= %d", h.cfg.WatchCount)
+	if h.cfg.WatchPoints > 0 {
+		having += fmt.Sprintf(" OR p >= %d", h.cfg.WatchPoints)
+	}
+
+	cond, args := scopeFor(c, perm.ViolationRead).where()
+	list, err := h.queryOffenders("WHERE v.created_at >= ? AND v.created_at < ?"+cond, having,
+		"p DESC, n DESC", append([]interface{}{start, end}, args...), 500)
+	if err != nil {
+		log.Printf("Watch list error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	for i := range list {
+		list[i].Watched = true
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":   list,
+		"start":  start.Format("2006-01-02"),
+		"end":    end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"days":   h.cfg.WatchDays,
+		"count":  h.cfg.WatchCount,
+		"points": h.cfg.WatchPoints,
+	})
+}
+
+func (h *Handler) queryOffenders(where, having, order string, args []interface{}, limit int) ([]offender, error) {
+	rows, err := h.db.Query(
+		"SELECT v.student_name, v.class_name, COUNT(*) AS n, SUM("+pointsExpr+") AS p, MAX(v.created_at)"+
+			offenderFrom+where+" GROUP BY v.student_name, v.class_name "+having+" ORDER BY "+order+" LIMIT ?",
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []offender{}
+	for rows.Next() {
+		var o offender
+		if err := rows.Scan(&o.StudentName, &o.ClassName, &o.Count, &o.Points, &o.LastAt); err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// watchWindow is the last WatchDays days, today included.
+func (h *Handler) watchWindow() (start, end time.Time) {
+	end = report.Day(time.Now()).AddDate(0, 0, 1)
+	return end.AddDate(0, 0, -h.cfg.WatchDays), end
+}
+
+// markWatched flags the students in list who are on the watch list, judged
+// by their records in scope over the watch window rather than the range
+// the list was queried for.
+func (h *Handler) markWatched(list []offender, scope recordScope) error {
+	if len(list) == 0 {
+		return nil
+	}
+	start, end := h.watchWindow()
+	cond, condArgs := scope.where()
+	args := append([]interface{}{start, end}, condArgs...)
+	pairs := make([]string, len(list))
+	for i, o := range list {
+		pairs[i] = "(?, ?)"
+		args = append(args, o.StudentName, o.ClassName)
+	}
+
+	rows, err := h.db.Query(
+		"SELECT v.student_name, v.class_name, COUNT(*), SUM("+pointsExpr+")"+offenderFrom+
+			"WHERE v.created_at >= ? AND v.created_at < ?"+cond+
+			" AND (v.student_name, v.class_name) IN ("+strings.Join(pairs, ", ")+")"+
+			" GROUP BY v.student_name, v.class_name",
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	watched := map[[2]string]bool{}
+	for rows.Next() {
+		var name, class string
+		var count, points int
+		if err := rows.Scan(&name, &class, &count, &points); err != nil {
+			return err
+		}
+		watched[[2]string{name, class}] = h.watched(count, points)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range list {
+		list[i].Watched = watched[[2]string{list[i].StudentName, list[i].ClassName}]
+	}
+	return nil
+}
+
+// watched applies the watch list threshold to a student's totals over the
+// watch window.
+func (h *Handler) watched(count, points int) bool {
+	return count >= h.cfg.WatchCount || (h.cfg.WatchPoints > 0 && points >= h.cfg.WatchPoints)
+}
+
+type timelineEntry struct {
+	model.Violation
+	Points   int    `json:"points"`
+	PhotoURL string `json:"photo_url,omitempty"`
+}
+
+// GetStudentTimeline lists every record of one student (name + class),
+// newest first, with links to the photos.
+func (h *Handler) GetStudentTimeline(c *gin.Context) {
+	name := strings.TrimSpace(c.Query("name"))
+	class := strings.TrimSpace(c.Query("class"))
+	if name == "" || class == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定学生姓名和班级"})
+		return
+	}
+
+	scope, ok := requireScope(c, perm.ViolationRead)
+	if !ok {
+		return
+	}
+	cond, condArgs := scope.where()
+	rows, err := h.db.Query(`
+		SELECT v.id, v.dorm, v.student_name, v.class_name, v.period, v.reason,
+		       v.department, v.category, v.inspector, v.photo_path, v.created_by, v.created_at,
+		       COALESCE(u.display_name, u.username) as creator_name, `+pointsExpr+`
+		`+offenderFrom+`
+		WHERE v.student_name = ? AND v.class_name = ?`+cond+`
+		ORDER BY v.created_at DESC
+	`, append([]interface{}{name, class}, condArgs...)...)
+	if err != nil {
+		log.Printf("Student timeline error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []timelineEntry{}
+	total := 0
+	for rows.Next() {
+		var e timelineEntry
+		v := &e.Violation
+		if err := rows.Scan(&v.ID, &v.Dorm, &v.StudentName, &v.ClassName, &v.Period, &v.Reason,
+			&v.Department, &v.Category, &v.Inspector, &v.PhotoPath, &v.CreatedBy, &v.CreatedAt,
+			&v.CreatorName, &e.Points); err != nil {
+			continue
+		}
+		if v.PhotoPath != "" {
+			e.PhotoURL = fmt.Sprintf("/api/violations/%d/photo", v.ID)
+		}
+		total += e.Points
+		entries = append(entries, e)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"student_name": name,
+		"class_name":   class,
+		"count":        len(entries),
+		"points":       total,
+		"data":         entries,
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"suv/internal/model"
+)
+
+// passwordChangeAllowed lists what an account flagged must_change_password
+// may still call.
+var passwordChangeAllowed = map[string]bool{
+	"/api/me":          true,
+	"/api/me/password": true,
+	"/api/logout":      true,
+}
+
+// ==================== Password ====================
+
+func (h *Handler) PasswordPage(c *gin.Context) {
+	user := getUser(c)
+	c.HTML(http.StatusOK, "password.html", gin.H{
+		"user":       user,
+		"csrf_token": getCSRF(c),
+	})
+}
+
+// RequirePasswordChanged blocks every API call except the password change
+// itself for accounts that still have to replace a default or
+// admin-assigned password. Install it after JWTAuth. The flag is read from
+// the database so an admin reset takes effect on existing sessions.
+func (h *Handler) RequirePasswordChanged() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if passwordChangeAllowed[c.FullPath()] {
+			c.Next()
+			return
+		}
+		var must bool
+		err := h.db.QueryRow("SELECT must_change_password FROM users WHERE id = ?", getUser(c).UserID).Scan(&must)
+		if err != nil && err != sql.ErrNoRows {
+			// Fail closed: without the flag we cannot tell.
+			log.Printf("Load must_change_password error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+			c.Abort()
+			return
+		}
+		if must {
+			c.JSON(http.StatusForbidden, gin.H{"error": "请先修改初始密码", "must_change_password": true})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ChangeMyPassword lets a logged-in user replace their own password after
+// confirming the current one.
+func (h *Handler) ChangeMyPassword(c *gin.Context) {
+	user := getUser(c)
+	var req model.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入当前密码和新密码"})
+		return
+	}
+
+	var hash string
+	if err := h.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", user.UserID).Scan(&hash); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+		h.audit(c, "user.password_change_failed", "user", user.UserID, nil, nil)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "当前密码错误"})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "新密码不能与当前密码相同"})
+		return
+	}
+	if err := h.checkPassword(user.UserID, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.setPassword(user.UserID, req.NewPassword, false); err != nil {
+		log.Printf("Change password error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "修改失败"})
+		return
+	}
+	// Whoever knew the old password is logged out; this device stays.
+	revoked := h.revokeUserSessions(user.UserID, user.SessionID, "password_change")
+	h.audit(c, "user.password_change", "user", user.UserID, nil, gin.H{"revoked_sessions": revoked})
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改"})
+}
+
+// maxPasswordBytes is bcrypt's input limit. It counts bytes, so a
+// Chinese password reaches it at 24 characters.
+const maxPasswordBytes = 72
+
+// checkPassword applies the password policy. userID 0 skips the reuse
+// check (new accounts).
+func (h *Handler) checkPassword(userID uint, password string) error {
+	if n := len([]rune(password)); n < h.cfg.PasswordMinLength {
+		return fmt.Errorf("密码至少 %d 位", h.cfg.PasswordMinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("密码太长（最多 %d 字节，一个汉字占 3 字节）", maxPasswordBytes)
+	}
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, b := range []bool{lower, upper, digit, other} {
+		if b {
+			classes++
+		}
+	}
+	if classes < h.cfg.PasswordClasses {
+		return fmt.Errorf("密码需包含小写字母、大写字母、数字、符号中的至少 %d 种", h.cfg.PasswordClasses)
+	}
+
+	if userID == 0 || h.cfg.PasswordHistory <= 0 {
+		return nil
+	}
+	// The current password counts as the most recent one.
+	rows, err := h.db.Query(`
+		(SELECT password_hash FROM users WHERE id = ?)
+		UNION ALL
+		(SELECT password_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?)
+	`, userID, userID, h.cfg.PasswordHistory-1)
+	if err != nil {
+		return fmt.Errorf("系统错误")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var old string
+		if rows.Scan(&old) == nil && bcrypt.CompareHashAndPassword([]byte(old), []byte(password)) == nil {
+			return fmt.Errorf("不能使用最近 %d 次用过的密码", h.cfg.PasswordHistory)
+		}
+	}
+	return nil
+}
+
+// setPassword stores a new password, moving the old hash into the
+// history. mustChange marks passwords someone else chose.
+func (h *Handler) setPassword(userID uint, password string, mustChange bool) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old string
+	if err := tx.QueryRow("SELECT password_hash FROM users WHERE id = ? FOR UPDATE", userID).Scan(&old); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO password_history (user_id, password_hash) VALUES (?, ?)", userID, old); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE users SET password_hash = ?, must_change_password = ?, password_changed_at = NOW() WHERE id = ?",
+		string(hash), mustChange, userID,
+	); err != nil {
+		return err
+	}
+	// Only the last few hashes are ever compared; drop the rest.
+	keep := h.cfg.PasswordHistory
+	if keep < 1 {
+		keep = 1
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+			SELECT id FROM (SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?) keep
+		)`, userID, userID, keep); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// flagDefaultAdmin marks an admin account still using the seeded password,
+// for databases created before must_change_password existed.
+func (h *Handler) flagDefaultAdmin() {
+	var id uint
+	var hash string
+	err := h.db.QueryRow("SELECT id, password_hash FROM users WHERE username = 'admin' AND must_change_password = 0").
+		Scan(&id, &hash)
+	if err != nil {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(defaultAdminPassword)) == nil {
+		h.db.Exec("UPDATE users SET must_change_password = 1 WHERE id = ?", id)
+		log.Println("Default admin password still in use: change required at next login")
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+)
+
+// Masking levels for the public screens.
+//
+//	none     full name and reason
+//	partial  张*三, reason cut to publicReasonLen characters
+//	strict   surname only (张**), no reason
+const (
+	maskNone    = "none"
+	maskPartial = "partial"
+	maskStrict  = "strict"
+)
+
+const publicReasonLen = 12
+
+// publicView projects a record for the public screens.
+func publicView(v model.Violation, level string) model.PublicViolation {
+	p := model.PublicViolation{
+		ID:          v.ID,
+		Dorm:        v.Dorm,
+		StudentName: v.StudentName,
+		ClassName:   v.ClassName,
+		Period:      v.Period,
+		Reason:      v.Reason,
+		Department:  v.Department,
+		Category:    v.Category,
+		CreatedAt:   v.CreatedAt,
+	}
+	switch level {
+	case maskNone:
+	case maskStrict:
+		p.StudentName = maskName(v.StudentName, true)
+		p.Reason = ""
+	default:
+		p.StudentName = maskName(v.StudentName, false)
+		p.Reason = truncate(v.Reason, publicReasonLen)
+	}
+	return p
+}
+
+// maskName keeps the first and last character of a name (张*三, 欧阳**华)
+// or, in strict mode, only the first.
+func maskName(name string, strict bool) string {
+	r := []rune(strings.TrimSpace(name))
+	switch {
+	case len(r) == 0:
+		return ""
+	case len(r) == 1:
+		return "*"
+	case strict || len(r) == 2:
+		return string(r[0]) + strings.Repeat("*", len(r)-1)
+	}
+	return string(r[0]) + strings.Repeat("*", len(r)-2) + string(r[len(r)-1])
+}
+
+func truncate(s string, n int) string {
+	r := []rune(strings.TrimSpace(s))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n]) + "…"
+}
+
+// displayScope is what a public request may see: the masking level and an
+// optional building/grade limit. ID is 0 for anonymous requests.
+type displayScope struct {
+	ID       uint
+	Mask     string
+	Building string
+	Grade    string
+}
+
+// where narrows a violations query (aliased v) to the scope.
+func (s displayScope) where() (string, []interface{}) {
+	sql := ""
+	args := []interface{}{}
+	if s.Building != "" {
+		sql += " AND " + buildingExpr + " = ?"
+		args = append(args, s.Building)
+	}
+	if s.Grade != "" {
+		sql += " AND v.class_name IN (SELECT name FROM classes WHERE grade = ?)"
+		args = append(args, s.Grade)
+	}
+	return sql, args
+}
+
+// publicScope authenticates a public feed request. Screens send their token
+// as ?token= (kiosk URLs) or "Authorization: Bearer"; without one the site
+// default applies, unless PUBLIC_OPEN is off. ok is false when a response
+// has already been written.
+func (h *Handler) publicScope(c *gin.Context) (displayScope, bool) {
+	token := c.Query("token")
+	if auth := c.GetHeader("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		if !h.cfg.PublicOpen {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "请使用屏幕令牌访问"})
+			return displayScope{}, false
+		}
+		return displayScope{Mask: h.cfg.PublicMask}, true
+	}
+
+	var s displayScope
+	var revoked sql.NullTime
+	err := h.db.QueryRow(
+		"SELECT id, mask_level, building, grade, revoked_at FROM displays WHERE token_hash = ?",
+		hashToken(token),
+	).Scan(&s.ID, &s.Mask, &s.Building, &s.Grade, &revoked)
+	if err != nil || revoked.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "屏幕令牌无效或已停用"})
+		return displayScope{}, false
+	}
+
+	// Screens poll and reconnect all day; only write once a minute.
+	h.db.Exec(`UPDATE displays SET last_seen_at = NOW(), last_seen_ip = ?
+		WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < NOW() - INTERVAL 1 MINUTE)`,
+		c.ClientIP(), s.ID)
+	return s, true
+}
+
+// newDisplayToken returns a random token and the prefix shown in the
+// admin list to tell screens apart.
+func newDisplayToken() (string, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := "dsp_" + base64.RawURLEncoding.EncodeToString(b)
+	return token, token[:10], nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ==================== Displays ====================
+
+func (h *Handler) ListDisplays(c *gin.Context) {
+	rows, err := h.db.Query(`SELECT id, name, mask_level, building, grade, token_prefix,
+		revoked_at, last_seen_at, last_seen_ip, created_at FROM displays ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	displays := []model.Display{}
+	for rows.Next() {
+		var d model.Display
+		var revoked, seen sql.NullTime
+		rows.Scan(&d.ID, &d.Name, &d.MaskLevel, &d.Building, &d.Grade, &d.TokenPrefix,
+			&revoked, &seen, &d.LastSeenIP, &d.CreatedAt)
+		if revoked.Valid {
+			d.RevokedAt = &revoked.Time
+		}
+		if seen.Valid {
+			d.LastSeenAt = &seen.Time
+		}
+		displays = append(displays, d)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": displays, "default_mask": h.cfg.PublicMask})
+}
+
+// CreateDisplay registers a screen and returns its token. The token is
+// shown only here and on rotation; only its hash is stored.
+func (h *Handler) CreateDisplay(c *gin.Context) {
+	var req model.DisplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+	token, prefix, err := newDisplayToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+
+	result, err := h.db.Exec(
+		"INSERT INTO displays (name, mask_level, building, grade, token_hash, token_prefix) VALUES (?, ?, ?, ?, ?, ?)",
+		strings.TrimSpace(req.Name), req.MaskLevel, strings.TrimSpace(req.Building), strings.TrimSpace(req.Grade),
+		hashToken(token), prefix)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "屏幕名称已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusOK, gin.H{"id": id, "token": token, "message": "屏幕创建成功，令牌只显示这一次"})
+}
+
+func (h *Handler) UpdateDisplay(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var req model.DisplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	_, err := h.db.Exec("UPDATE displays SET name = ?, mask_level = ?, building = ?, grade = ? WHERE id = ?",
+		strings.TrimSpace(req.Name), req.MaskLevel, strings.TrimSpace(req.Building), strings.TrimSpace(req.Grade), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "屏幕名称已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+// RotateDisplayToken issues a new token for a screen, invalidating the old
+// one. It also re-enables a revoked screen.
+func (h *Handler) RotateDisplayToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	token, prefix, err := newDisplayToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+	result, err := h.db.Exec("UPDATE displays SET token_hash = ?, token_prefix = ?, revoked_at = NULL WHERE id = ?",
+		hashToken(token), prefix, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "屏幕不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "message": "令牌已更换，旧令牌失效"})
+}
+
+// RevokeDisplay stops a screen's token from working. Open streams notice
+// within a heartbeat.
+func (h *Handler) RevokeDisplay(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("UPDATE displays SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "屏幕不存在或已停用"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已停用"})
+}
+
+func (h *Handler) DeleteDisplay(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM displays WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "屏幕不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
@@ -0,0 +1,402 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/report"
+)
+
+// ==================== Weekly Ranking / 流动红旗 ====================
+//
+// Classes are ranked within their grade by deduction points per 100
+// students for the week (Monday to Sunday), lowest first. Every class
+// sharing rank 1 gets the red flag. Once a week is locked the ranking is
+// stored and no longer follows later edits to records or the roster.
+
+type gradeRanking struct {
+	Grade   string            `json:"grade"`
+	Classes []model.ClassRank `json:"classes"`
+	Winners []string          `json:"winners"`
+}
+
+type unmatchedClass struct {
+	ClassName string `json:"class_name"`
+	Count     int    `json:"count"`
+}
+
+func (h *Handler) GetWeeklyRanking(c *gin.Context) {
+	weekStart, err := parseWeek(c.Query("week"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"week_start": weekStart.Format("2006-01-02"),
+		"week_end":   weekStart.AddDate(0, 0, 6).Format("2006-01-02"),
+	}
+
+	var lockedAt sql.NullTime
+	h.db.QueryRow("SELECT MAX(locked_at) FROM red_flag_awards WHERE week_start = ?", weekStart).Scan(&lockedAt)
+
+	var ranks []model.ClassRank
+	if lockedAt.Valid {
+		ranks, err = h.lockedRanking(weekStart)
+		resp["locked"] = true
+		resp["locked_at"] = lockedAt.Time
+	} else {
+		var unmatched []unmatchedClass
+		ranks, unmatched, err = h.computeRanking(weekStart)
+		resp["locked"] = false
+		resp["unmatched"] = unmatched
+	}
+	if err != nil {
+		log.Printf("Weekly ranking error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	resp["grades"] = groupRanking(ranks)
+	c.JSON(http.StatusOK, resp)
+}
+
+// LockWeeklyRanking stores the week's ranking and its red flag winners.
+func (h *Handler) LockWeeklyRanking(c *gin.Context) {
+	var body struct {
+		Week string `json:"week" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请指定周次"})
+		return
+	}
+	weekStart, err := parseWeek(body.Week)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !weekStart.AddDate(0, 0, 7).Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "本周尚未结束，不能锁定"})
+		return
+	}
+
+	ranks, _, err := h.computeRanking(weekStart)
+	if err != nil {
+		log.Printf("Lock ranking compute error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "计算失败"})
+		return
+	}
+	if len(ranks) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "班级名单为空，请先维护班级"})
+		return
+	}
+
+	user := getUser(c)
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "锁定失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	var exists int
+	tx.QueryRow("SELECT COUNT(*) FROM red_flag_awards WHERE week_start = ? FOR UPDATE", weekStart).Scan(&exists)
+	if exists > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "该周已锁定"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM class_week_ranks WHERE week_start = ?", weekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "锁定失败"})
+		return
+	}
+	for _, r := range ranks {
+		_, err := tx.Exec(`INSERT INTO class_week_ranks
+			(week_start, grade, class_name, student_count, violation_count, points, rate, rank_no)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			weekStart, r.Grade, r.ClassName, r.StudentCount, r.ViolationCount, r.Points, r.Rate, r.Rank)
+		if err == nil && r.Rank == 1 {
+			_, err = tx.Exec(`INSERT INTO red_flag_awards (week_start, grade, class_name, points, rate, locked_by)
+				VALUES (?, ?, ?, ?, ?, ?)`, weekStart, r.Grade, r.ClassName, r.Points, r.Rate, user.UserID)
+		}
+		if err != nil {
+			log.Printf("Lock ranking insert error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "锁定失败"})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "锁定失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已锁定", "grades": groupRanking(ranks)})
+}
+
+// UnlockWeeklyRanking discards a locked result so it can be recomputed,
+// e.g. after a wrongly recorded violation was corrected.
+func (h *Handler) UnlockWeeklyRanking(c *gin.Context) {
+	weekStart, err := parseWeek(c.Query("week"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM red_flag_awards WHERE week_start = ?", weekStart)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM class_week_ranks WHERE week_start = ?", weekStart)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该周未锁定"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解除锁定"})
+}
+
+func (h *Handler) ListRedFlagAwards(c *gin.Context) {
+	awards, err := h.queryRedFlagAwards(c.Query("grade"), c.Query("class"))
+	if err != nil {
+		log.Printf("Red flag awards error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": awards})
+}
+
+func (h *Handler) ExportRedFlagAwards(c *gin.Context) {
+	awards, err := h.queryRedFlagAwards(c.Query("grade"), c.Query("class"))
+	if err != nil {
+		log.Printf("Red flag export error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	setAttachment(c, "流动红旗.csv")
+	c.Writer.WriteString("\xEF\xBB\xBF")
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"周次", "年级", "班级", "扣分", "每百人扣分", "锁定人", "锁定时间"})
+	for _, a := range awards {
+		start, _ := time.ParseInLocation("2006-01-02", a.WeekStart, time.Local)
+		w.Write([]string{
+			a.WeekStart + " 至 " + start.AddDate(0, 0, 6).Format("2006-01-02"),
+			a.Grade, a.ClassName, strconv.Itoa(a.Points), strconv.FormatFloat(a.Rate, 'f', 2, 64),
+			a.LockedByName, a.LockedAt.Format("2006-01-02 15:04"),
+		})
+	}
+	w.Flush()
+	h.auditExport(c, "export.red_flags", "", nil, w.Error())
+}
+
+func (h *Handler) queryRedFlagAwards(grade, class string) ([]model.RedFlagAward, error) {
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	if grade != "" {
+		where += " AND a.grade = ?"
+		args = append(args, grade)
+	}
+	if class != "" {
+		where += " AND a.class_name = ?"
+		args = append(args, class)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT a.id, DATE_FORMAT(a.week_start, '%Y-%m-%d'), a.grade, a.class_name, a.points, a.rate,
+		       a.locked_by, COALESCE(u.display_name, u.username, ''), a.locked_at
+		FROM red_flag_awards a
+		LEFT JOIN users u ON a.locked_by = u.id
+		`+where+`
+		ORDER BY a.week_start DESC, a.grade, a.class_name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	awards := []model.RedFlagAward{}
+	for rows.Next() {
+		var a model.RedFlagAward
+		if err := rows.Scan(&a.ID, &a.WeekStart, &a.Grade, &a.ClassName, &a.Points, &a.Rate,
+			&a.LockedBy, &a.LockedByName, &a.LockedAt); err != nil {
+			return nil, err
+		}
+		awards = append(awards, a)
+	}
+	return awards, rows.Err()
+}
+
+// computeRanking ranks every class on the roster for the week starting at
+// weekStart. Violations whose class is not on the roster are returned
+// separately so the roster can be fixed.
+func (h *Handler) computeRanking(weekStart time.Time) ([]model.ClassRank, []unmatchedClass, error) {
+	roster := map[string]*model.ClassRank{}
+	rows, err := h.db.Query("SELECT name, grade, student_count FROM classes WHERE student_count > 0")
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		r := &model.ClassRank{}
+		if err := rows.Scan(&r.ClassName, &r.Grade, &r.StudentCount); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		roster[r.ClassName] = r
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT v.class_name, COUNT(*), SUM(`+pointsExpr+`)
+		FROM violations v
+		LEFT JOIN categories c ON c.name = v.category
+		WHERE v.created_at >= ? AND v.created_at < ?
+		GROUP BY v.class_name
+	`, weekStart, weekStart.AddDate(0, 0, 7))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	unmatched := []unmatchedClass{}
+	for rows.Next() {
+		var name string
+		var count, points int
+		if err := rows.Scan(&name, &count, &points); err != nil {
+			return nil, nil, err
+		}
+		if r, ok := roster[name]; ok {
+			r.ViolationCount, r.Points = count, points
+		} else {
+			unmatched = append(unmatched, unmatchedClass{name, count})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	ranks := make([]model.ClassRank, 0, len(roster))
+	for _, r := range roster {
+		r.Rate = math.Round(float64(r.Points)*100/float64(r.StudentCount)*100) / 100
+		ranks = append(ranks, *r)
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		a, b := ranks[i], ranks[j]
+		if a.Grade != b.Grade {
+			return a.Grade < b.Grade
+		}
+		if a.Rate != b.Rate {
+			return a.Rate < b.Rate
+		}
+		return a.ClassName < b.ClassName
+	})
+
+	// Competition ranking within each grade: equal rates share a rank.
+	for i := range ranks {
+		switch {
+		case i == 0 || ranks[i].Grade != ranks[i-1].Grade:
+			ranks[i].Rank = 1
+		case ranks[i].Rate == ranks[i-1].Rate:
+			ranks[i].Rank = ranks[i-1].Rank
+		default:
+			n := 1
+			for j := i - 1; j >= 0 && ranks[j].Grade == ranks[i].Grade; j-- {
+				n++
+			}
+			ranks[i].Rank = n
+		}
+	}
+	sort.Slice(unmatched, func(i, j int) bool { return unmatched[i].ClassName < unmatched[j].ClassName })
+	return ranks, unmatched, nil
+}
+
+func (h *Handler) lockedRanking(weekStart time.Time) ([]model.ClassRank, error) {
+	rows, err := h.db.Query(`
+		SELECT rank_no, class_name, grade, student_count, violation_count, points, rate
+		FROM class_week_ranks
+		WHERE week_start = ?
+		ORDER BY grade, rank_no, class_name
+	`, weekStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranks := []model.ClassRank{}
+	for rows.Next() {
+		var r model.ClassRank
+		if err := rows.Scan(&r.Rank, &r.ClassName, &r.Grade, &r.StudentCount, &r.ViolationCount,
+			&r.Points, &r.Rate); err != nil {
+			return nil, err
+		}
+		ranks = append(ranks, r)
+	}
+	return ranks, rows.Err()
+}
+
+// groupRanking splits a ranking sorted by grade into per-grade lists.
+func groupRanking(ranks []model.ClassRank) []gradeRanking {
+	grades := []gradeRanking{}
+	for _, r := range ranks {
+		if len(grades) == 0 || grades[len(grades)-1].Grade != r.Grade {
+			grades = append(grades, gradeRanking{Grade: r.Grade, Classes: []model.ClassRank{}, Winners: []string{}})
+		}
+		g := &grades[len(grades)-1]
+		g.Classes = append(g.Classes, r)
+		if r.Rank == 1 {
+			g.Winners = append(g.Winners, r.ClassName)
+		}
+	}
+	return grades
+}
+
+// parseWeek returns the Monday of the week containing the given date, or
+// of last week when the date is empty.
+func parseWeek(s string) (time.Time, error) {
+	if strings.TrimSpace(s) == "" {
+		return report.WeekStart(time.Now()).AddDate(0, 0, -7), nil
+	}
+	d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日期格式错误")
+	}
+	return report.WeekStart(d), nil
+}
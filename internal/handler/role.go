@@ -0,0 +1,218 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// seedRoles creates the built-in roles that are missing. Existing rows
+// are left alone so edits survive restarts.
+func (h *Handler) seedRoles() {
+	for _, r := range perm.Builtin {
+		_, err := h.db.Exec("INSERT IGNORE INTO roles (name, label, permissions, builtin) VALUES (?, ?, ?, 1)",
+			r.Name, r.Label, strings.Join(r.Permissions, ","))
+		if err != nil {
+			log.Printf("Seed role %s error: %v", r.Name, err)
+		}
+	}
+	h.roles.Invalidate()
+}
+
+func (h *Handler) roleExists(name string) bool {
+	var n int
+	h.db.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ?", name).Scan(&n)
+	return n > 0
+}
+
+// mayGrant reports whether the caller holds every permission of role, so
+// that assigning it, or acting on a user who has it, is no escalation.
+// Only "*" covers admin.
+func (h *Handler) mayGrant(c *gin.Context, role string) bool {
+	caller := getUser(c).Perms
+	if role == "admin" && !caller[perm.All] {
+		return false
+	}
+	return caller.Covers(h.roles.Permissions(role))
+}
+
+// ==================== Roles ====================
+
+// ListPermissions returns the permission catalogue for the role editor.
+func (h *Handler) ListPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": perm.Catalog})
+}
+
+func (h *Handler) ListRoles(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT r.id, r.name, r.label, r.permissions, r.builtin, r.created_at,
+		       (SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		FROM roles r
+		ORDER BY r.builtin DESC, r.id
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	list := []model.Role{}
+	for rows.Next() {
+		var r model.Role
+		var perms string
+		if err := rows.Scan(&r.ID, &r.Name, &r.Label, &perms, &r.Builtin, &r.CreatedAt, &r.Users); err != nil {
+			continue
+		}
+		r.Permissions = perm.Parse(perms).List()
+		list = append(list, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) CreateRole(c *gin.Context) {
+	req, perms, ok := bindRole(c)
+	if !ok {
+		return
+	}
+	result, err := h.db.Exec("INSERT INTO roles (name, label, permissions) VALUES (?, ?, ?)",
+		req.Name, req.Label, perms.String())
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate") {
+			c.JSON(http.StatusConflict, gin.H{"error": "角色已存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+	h.roles.Invalidate()
+	id, _ := result.LastInsertId()
+	h.audit(c, "role.create", "role", id, nil, gin.H{"name": req.Name, "permissions": perms.List()})
+	c.JSON(http.StatusOK, gin.H{"id": id, "message": "角色已创建"})
+}
+
+// UpdateRole changes a role's label and permissions. The name is fixed
+// since users refer to it. Changes apply to logged-in users right away.
+func (h *Handler) UpdateRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	req, perms, ok := bindRole(c)
+	if !ok {
+		return
+	}
+
+	var name, before string
+	if err := h.db.QueryRow("SELECT name, permissions FROM roles WHERE id = ?", id).Scan(&name, &before); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+	if name == "admin" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "管理员角色不能修改"})
+		return
+	}
+	if req.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色标识不能修改"})
+		return
+	}
+	if !getUser(c).Perms.Covers(perm.Parse(before)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能修改权限比自己大的角色"})
+		return
+	}
+	if _, err := h.db.Exec("UPDATE roles SET label = ?, permissions = ? WHERE id = ?",
+		req.Label, perms.String(), id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	h.roles.Invalidate()
+	h.audit(c, "role.update", "role", id,
+		gin.H{"permissions": perm.Parse(before).List()}, gin.H{"label": req.Label, "permissions": perms.List()})
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+// DeleteRole removes a custom role nobody has.
+func (h *Handler) DeleteRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	var name string
+	var builtin bool
+	if err := h.db.QueryRow("SELECT name, builtin FROM roles WHERE id = ?", id).Scan(&name, &builtin); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "角色不存在"})
+		return
+	}
+	if builtin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "内置角色不能删除"})
+		return
+	}
+	var users int
+	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", name).Scan(&users)
+	if users > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "还有 " + strconv.Itoa(users) + " 个用户使用该角色"})
+		return
+	}
+	if _, err := h.db.Exec("DELETE FROM roles WHERE id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	h.roles.Invalidate()
+	h.audit(c, "role.delete", "role", id, gin.H{"name": name}, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+func bindRole(c *gin.Context) (model.RoleRequest, perm.Set, bool) {
+	var req model.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return req, nil, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Label = strings.TrimSpace(req.Label)
+	if !roleNamePattern.MatchString(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "角色标识只能使用小写字母、数字和下划线"})
+		return req, nil, false
+	}
+	perms := perm.Set{}
+	for _, p := range req.Permissions {
+		p = strings.TrimSpace(p)
+		if p == perm.All || !perm.Valid(p) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "未知权限: " + p})
+			return req, nil, false
+		}
+		perms[p] = true
+	}
+	if !getUser(c).Perms.Covers(perms) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "不能授予自己没有的权限"})
+		return req, nil, false
+	}
+	if req.Label == "" {
+		req.Label = req.Name
+	}
+	return req, perms, true
+}
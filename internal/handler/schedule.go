@@ -0,0 +1,354 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+	"suv/internal/report"
+	"suv/internal/scheduler"
+)
+
+// StartScheduler starts running the configured report jobs. Call it once
+// at startup after Migrate.
+func (h *Handler) StartScheduler() {
+	h.ensureBuildingReports()
+	h.sched.Start()
+}
+
+// bedtimePeriod is the period the nightly building summary covers.
+const bedtimePeriod = "晚休"
+
+// ensureBuildingReports creates the nightly 晚休 summary job of every
+// building that has a user but no such job yet, so a dorm supervisor gets
+// their building's report as soon as they are given a building. Jobs that
+// exist are left alone: an admin may change their time or disable them
+// (a deleted one comes back).
+func (h *Handler) ensureBuildingReports() {
+	cron := h.cfg.BedtimeReportCron
+	if cron == "off" {
+		return
+	}
+	if _, err := scheduler.Parse(cron); err != nil {
+		log.Printf("BEDTIME_REPORT_CRON %q is invalid: %v", cron, err)
+		return
+	}
+
+	rows, err := h.db.Query(`SELECT DISTINCT u.building FROM users u
+		WHERE u.building <> ''
+		  AND NOT EXISTS (SELECT 1 FROM report_jobs j WHERE j.auto_created = 1 AND j.building = u.building)`)
+	if err != nil {
+		log.Printf("Load buildings error: %v", err)
+		return
+	}
+	var buildings []string
+	for rows.Next() {
+		var b string
+		if rows.Scan(&b) == nil {
+			buildings = append(buildings, b)
+		}
+	}
+	rows.Close()
+
+	for _, b := range buildings {
+		_, err := h.db.Exec(`INSERT INTO report_jobs
+			(name, cron, format, range_name, building, period, auto_created, retention, enabled)
+			VALUES (?, ?, 'pdf', 'today', ?, ?, 1, 30, 1)`,
+			b+"号楼"+bedtimePeriod+"汇总", cron, b, bedtimePeriod)
+		if err != nil {
+			log.Printf("Create bedtime report for building %s error: %v", b, err)
+			continue
+		}
+		log.Printf("Created nightly %s report job for building %s", bedtimePeriod, b)
+	}
+}
+
+// ==================== Report Jobs (Admin) ====================
+
+func (h *Handler) ListReportJobs(c *gin.Context) {
+	jobs, err := h.sched.Jobs(false)
+	if err != nil {
+		log.Printf("List report jobs error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": jobs})
+}
+
+func (h *Handler) CreateReportJob(c *gin.Context) {
+	req, ok := bindReportJob(c)
+	if !ok {
+		return
+	}
+
+	enabled := req.Enabled == nil || *req.Enabled
+	result, err := h.db.Exec(
+		"INSERT INTO report_jobs (name, cron, format, range_name, building, period, retention, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		req.Name, req.Cron, req.Format, req.Range, req.Building, req.Period, req.Retention, enabled,
+	)
+	if err != nil {
+		log.Printf("Create report job error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建失败"})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	c.JSON(http.StatusOK, gin.H{"id": id, "message": "任务创建成功"})
+}
+
+func (h *Handler) UpdateReportJob(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	req, ok := bindReportJob(c)
+	if !ok {
+		return
+	}
+
+	enabled := req.Enabled == nil || *req.Enabled
+	result, err := h.db.Exec(
+		"UPDATE report_jobs SET name = ?, cron = ?, format = ?, range_name = ?, building = ?, period = ?, retention = ?, enabled = ? WHERE id = ?",
+		req.Name, req.Cron, req.Format, req.Range, req.Building, req.Period, req.Retention, enabled, id,
+	)
+	if err != nil {
+		log.Printf("Update report job error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := h.sched.Job(uint(id)); errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "保存成功"})
+}
+
+func (h *Handler) DeleteReportJob(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+
+	// Files already written stay in the output directory.
+	result, err := h.db.Exec("DELETE FROM report_jobs WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// RunReportJob triggers a job immediately in the background; the outcome
+// shows up in the run history.
+func (h *Handler) RunReportJob(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+
+	job, err := h.sched.Job(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+
+	go func() {
+		if err := h.sched.Run(job, time.Now()); err != nil && !errors.Is(err, scheduler.ErrRunning) {
+			log.Printf("Report job %d (%s) failed: %v", job.ID, job.Name, err)
+		}
+	}()
+	c.JSON(http.StatusOK, gin.H{"message": "任务已开始执行"})
+}
+
+func (h *Handler) ListReportJobRuns(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	if jobID := c.Query("job_id"); jobID != "" {
+		where += " AND r.job_id = ?"
+		args = append(args, jobID)
+	}
+	if status := c.Query("status"); status != "" {
+		where += " AND r.status = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	h.db.QueryRow("SELECT COUNT(*) FROM report_job_runs r "+where, args...).Scan(&total)
+
+	rows, err := h.db.Query(`
+		SELECT r.id, r.job_id, j.name, r.status, r.file_path, r.file_size,
+		       COALESCE(r.error, ''), r.started_at, r.finished_at
+		FROM report_job_runs r
+		JOIN report_jobs j ON r.job_id = j.id
+		`+where+`
+		ORDER BY r.started_at DESC, r.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		log.Printf("List report job runs error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	runs := []model.ReportJobRun{}
+	for rows.Next() {
+		var r model.ReportJobRun
+		var finished sql.NullTime
+		if err := rows.Scan(&r.ID, &r.JobID, &r.JobName, &r.Status, &r.FilePath, &r.FileSize,
+			&r.Error, &r.StartedAt, &finished); err != nil {
+			continue
+		}
+		if finished.Valid {
+			r.FinishedAt = &finished.Time
+		}
+		runs = append(runs, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  runs,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func bindReportJob(c *gin.Context) (model.ReportJobRequest, bool) {
+	var req model.ReportJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return req, false
+	}
+	if _, err := scheduler.Parse(req.Cron); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cron 表达式无效: " + err.Error()})
+		return req, false
+	}
+	if _, _, ok := report.NamedRange(req.Range, time.Now()); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的日期范围"})
+		return req, false
+	}
+	building, ok := bindBuilding(c, req.Building)
+	if !ok {
+		return req, false
+	}
+	req.Building = building
+	req.Period = strings.TrimSpace(req.Period)
+	return req, true
+}
+
+// ==================== Building Reports ====================
+
+// ListMyReports lists the finished runs of report jobs limited to the
+// user's building, e.g. the nightly 晚休 summary for a dorm supervisor.
+func (h *Handler) ListMyReports(c *gin.Context) {
+	user := getUser(c)
+	runs := []model.ReportJobRun{}
+	if user.Building == "" {
+		c.JSON(http.StatusOK, gin.H{"data": runs})
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT r.id, r.job_id, j.name, r.status, r.file_path, r.file_size, r.started_at, r.finished_at
+		FROM report_job_runs r
+		JOIN report_jobs j ON r.job_id = j.id
+		WHERE j.building = ? AND r.status = 'success'
+		ORDER BY r.started_at DESC, r.id DESC
+		LIMIT 50
+	`, user.Building)
+	if err != nil {
+		log.Printf("List building reports error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r model.ReportJobRun
+		var finished sql.NullTime
+		if err := rows.Scan(&r.ID, &r.JobID, &r.JobName, &r.Status, &r.FilePath, &r.FileSize,
+			&r.StartedAt, &finished); err != nil {
+			continue
+		}
+		if finished.Valid {
+			r.FinishedAt = &finished.Time
+		}
+		// The server path is of no use to the client.
+		r.FilePath = filepath.Base(r.FilePath)
+		runs = append(runs, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": runs})
+}
+
+// DownloadReportRun serves the file of a finished run to users who manage
+// report jobs and to users of the building the job is limited to.
+func (h *Handler) DownloadReportRun(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+
+	var path, building string
+	err := h.db.QueryRow(`SELECT r.file_path, j.building FROM report_job_runs r
+		JOIN report_jobs j ON r.job_id = j.id WHERE r.id = ? AND r.status = 'success'`, id).Scan(&path, &building)
+	if err != nil || path == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "报表不存在"})
+		return
+	}
+	user := getUser(c)
+	if !user.Perms.Has(perm.Settings) && (building == "" || building != user.Building) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "报表文件已清理"})
+		return
+	}
+
+	h.audit(c, "report.download", "report_run", id, nil, nil)
+	setAttachment(c, filepath.Base(path))
+	c.File(path)
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/model"
+	"suv/internal/perm"
+)
+
+// recordScope is the part of the violations table a user may act on for
+// one permission. Routes are guarded by middleware.Require; handlers use
+// the scope to filter queries and to check single records.
+type recordScope struct {
+	level      perm.Level
+	userID     uint
+	department string
+	building   string
+	classes    []string
+}
+
+// scopeFor works out what the logged-in user may do with action. Requests
+// without a user get nothing; code running on its own behalf (scheduled
+// jobs) builds a recordScope itself.
+func scopeFor(c *gin.Context, action string) recordScope {
+	v, ok := c.Get("user")
+	if !ok {
+		return recordScope{level: perm.None}
+	}
+	u := v.(model.Claims)
+	return recordScope{level: u.Perms.Level(action), userID: u.UserID, department: u.Department,
+		building: u.Building, classes: u.Classes}
+}
+
+// requireScope is scopeFor that answers 403 when action is not granted at
+// all.
+func requireScope(c *gin.Context, action string) (recordScope, bool) {
+	s := scopeFor(c, action)
+	if s.level == perm.None {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return s, false
+	}
+	return s, true
+}
+
+// where returns an " AND ..." condition on the violations alias v.
+func (s recordScope) where() (string, []interface{}) {
+	switch s.level {
+	case perm.Any:
+		return "", nil
+	case perm.Dept:
+		if s.department == "" {
+			// A department-scoped role without a department sees nothing,
+			// not the records that have none.
+			return " AND 1=0", nil
+		}
+		return " AND v.department = ?", []interface{}{s.department}
+	case perm.Building:
+		if s.building == "" {
+			return " AND 1=0", nil
+		}
+		return " AND " + buildingExpr + " = ?", []interface{}{s.building}
+	case perm.Class:
+		if len(s.classes) == 0 {
+			return " AND 1=0", nil
+		}
+		args := make([]interface{}, len(s.classes))
+		for i, name := range s.classes {
+			args[i] = name
+		}
+		return " AND v.class_name IN (?" + strings.Repeat(", ?", len(s.classes)-1) + ")", args
+	case perm.Own:
+		return " AND v.created_by = ?", []interface{}{s.userID}
+	}
+	return " AND 1=0", nil
+}
+
+func (s recordScope) allows(v *model.Violation) bool {
+	switch s.level {
+	case perm.Any:
+		return true
+	case perm.Dept:
+		return s.department != "" && v.Department == s.department
+	case perm.Building:
+		return s.building != "" && dormBuilding(v.Dorm) == s.building
+	case perm.Class:
+		for _, name := range s.classes {
+			if v.ClassName == name {
+				return true
+			}
+		}
+		return false
+	case perm.Own:
+		return v.CreatedBy == s.userID
+	}
+	return false
+}
+
+// denyMessage explains a failed allows check.
+func (s recordScope) denyMessage() string {
+	switch s.level {
+	case perm.Dept:
+		return "只能操作本部门的记录"
+	case perm.Building:
+		return "只能操作本楼宿舍的记录"
+	case perm.Class:
+		return "只能查看所带班级的记录"
+	case perm.Own:
+		return "只能操作自己录入的记录"
+	}
+	return "权限不足"
+}
@@ -0,0 +1,88 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"suv/internal/model"
+	"suv/internal/perm"
+)
+
+func TestRecordScopeWhere(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope recordScope
+		cond  string
+		args  []interface{}
+	}{
+		{"any", recordScope{level: perm.Any, department: "纪检部"}, "", nil},
+		{"dept", recordScope{level: perm.Dept, department: "纪检部"},
+			" AND v.department = ?", []interface{}{"纪检部"}},
+		{"building", recordScope{level: perm.Building, building: "3"},
+			" AND " + buildingExpr + " = ?", []interface{}{"3"}},
+		{"one class", recordScope{level: perm.Class, classes: []string{"高一1班"}},
+			" AND v.class_name IN (?)", []interface{}{"高一1班"}},
+		{"classes", recordScope{level: perm.Class, classes: []string{"高一1班", "高一2班", "高二3班"}},
+			" AND v.class_name IN (?, ?, ?)", []interface{}{"高一1班", "高一2班", "高二3班"}},
+		{"own", recordScope{level: perm.Own, userID: 7}, " AND v.created_by = ?", []interface{}{uint(7)}},
+
+		// Missing attributes match nothing rather than everything.
+		{"dept without department", recordScope{level: perm.Dept}, " AND 1=0", nil},
+		{"building without building", recordScope{level: perm.Building}, " AND 1=0", nil},
+		{"class without classes", recordScope{level: perm.Class}, " AND 1=0", nil},
+		{"class with empty list", recordScope{level: perm.Class, classes: []string{}}, " AND 1=0", nil},
+		{"none", recordScope{level: perm.None, userID: 7, department: "纪检部"}, " AND 1=0", nil},
+	}
+	for _, tt := range tests {
+		cond, args := tt.scope.where()
+		if cond != tt.cond || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: where() = %q, %v, want %q, %v", tt.name, cond, args, tt.cond, tt.args)
+		}
+	}
+}
+
+func TestRecordScopeAllows(t *testing.T) {
+	v := &model.Violation{Dorm: "3-201", ClassName: "高一1班", Department: "纪检部", CreatedBy: 7}
+	noDorm := &model.Violation{Dorm: "201", CreatedBy: 7}
+
+	tests := []struct {
+		name  string
+		scope recordScope
+		v     *model.Violation
+		want  bool
+	}{
+		{"any", recordScope{level: perm.Any}, v, true},
+		{"dept match", recordScope{level: perm.Dept, department: "纪检部"}, v, true},
+		{"dept other", recordScope{level: perm.Dept, department: "学生会"}, v, false},
+		{"dept empty", recordScope{level: perm.Dept}, noDorm, false},
+		{"building match", recordScope{level: perm.Building, building: "3"}, v, true},
+		{"building other", recordScope{level: perm.Building, building: "4"}, v, false},
+		{"building empty", recordScope{level: perm.Building}, noDorm, false},
+		{"class match", recordScope{level: perm.Class, classes: []string{"高二1班", "高一1班"}}, v, true},
+		{"class other", recordScope{level: perm.Class, classes: []string{"高二1班"}}, v, false},
+		{"class none", recordScope{level: perm.Class}, v, false},
+		{"own match", recordScope{level: perm.Own, userID: 7}, v, true},
+		{"own other", recordScope{level: perm.Own, userID: 8}, v, false},
+		{"none", recordScope{level: perm.None, userID: 7}, v, false},
+	}
+	for _, tt := range tests {
+		if got := tt.scope.allows(tt.v); got != tt.want {
+			t.Errorf("%s: allows() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/middleware"
+	"suv/internal/model"
+)
+
+// Every login gets a row in sessions; the token's jti claim points at it.
+// middleware.JWTAuth rejects tokens whose row is revoked, expired or gone
+// (rows are deleted with their user).
+//
+// Access tokens are short-lived JWTs. The session is kept alive by an
+// opaque refresh token, stored hashed in refresh_tokens, that is replaced
+// on every use. Presenting a refresh token that was already used means it
+// was copied, so the whole session is revoked.
+
+// refreshReuseGrace tolerates two browser tabs refreshing at the same
+// moment with the same cookie: the loser is told to retry instead of
+// having the session killed.
+const refreshReuseGrace = 10 * time.Second
+
+func (h *Handler) accessTTL() time.Duration {
+	return time.Duration(h.cfg.AccessTokenTTL) * time.Minute
+}
+
+func (h *Handler) refreshTTL() time.Duration {
+	return time.Duration(h.cfg.RefreshTokenTTL) * time.Hour
+}
+
+// startSession records a login and returns its access and refresh tokens.
+func (h *Handler) startSession(c *gin.Context, user model.User) (string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	jti := hex.EncodeToString(b)
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	expires := now.Add(h.refreshTTL())
+
+	// Expired rows are only kept around for a week so the list stays short.
+	h.db.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at < NOW() - INTERVAL 7 DAY", user.ID)
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(
+		`INSERT INTO sessions (jti, user_id, ip, user_agent, last_seen_at, expires_at)
+		 VALUES (?, ?, ?, ?, NOW(), ?)`,
+		jti, user.ID, c.ClientIP(), userAgent(c), expires,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	sessionID, _ := result.LastInsertId()
+	if _, err := tx.Exec("INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		sessionID, hashToken(refresh), expires); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+
+	access, err := middleware.GenerateToken(h.keys, user, jti, now.Add(h.accessTTL()))
+	return access, refresh, err
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "rt_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setAuthCookies hands both tokens to the browser. The refresh cookie is
+// only sent to the refresh endpoint.
+func (h *Handler) setAuthCookies(c *gin.Context, access, refresh string) {
+	c.SetCookie("token", access, int(h.accessTTL()/time.Second), "/", "", false, true)
+	c.SetCookie("refresh_token", refresh, int(h.refreshTTL()/time.Second), "/api/refresh", "", false, true)
+}
+
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie("token", "", -1, "/", "", false, true)
+	c.SetCookie("refresh_token", "", -1, "/api/refresh", "", false, true)
+}
+
+// Refresh exchanges a refresh token (cookie, or "refresh_token" in the JSON
+// body for API clients) for a new access token and a new refresh token.
+func (h *Handler) Refresh(c *gin.Context) {
+	token, _ := c.Cookie("refresh_token")
+	if token == "" {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		c.ShouldBindJSON(&body)
+		token = body.RefreshToken
+	}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID uint64
+	var expires time.Time
+	var used, revoked sql.NullTime
+	var user model.User
+	var jti string
+	err = tx.QueryRow(`
+		SELECT r.id, r.session_id, r.expires_at, r.used_at, s.jti, s.revoked_at,
+		       u.id, u.username, u.display_name, u.role
+		FROM refresh_tokens r
+		JOIN sessions s ON s.id = r.session_id
+		JOIN users u ON u.id = s.user_id
+		WHERE r.token_hash = ?
+		FOR UPDATE
+	`, hashToken(token)).Scan(&tokenID, &sessionID, &expires, &used, &jti, &revoked,
+		&user.ID, &user.Username, &user.DisplayName, &user.Role)
+	if err == sql.ErrNoRows {
+		clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期"})
+		return
+	}
+	if err != nil {
+		log.Printf("Refresh token error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+	if revoked.Valid || time.Now().After(expires) {
+		clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录"})
+		return
+	}
+	if used.Valid {
+		if time.Since(used.Time) < refreshReuseGrace {
+			c.JSON(http.StatusConflict, gin.H{"error": "令牌已刷新，请重试"})
+			return
+		}
+		_, err := tx.Exec("UPDATE sessions SET revoked_at = NOW(), revoked_reason = 'refresh_reuse' WHERE id = ?", sessionID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			// The session is still alive; do not claim otherwise.
+			log.Printf("Revoke reused refresh token error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+			return
+		}
+		h.auditAs(c, user.ID, user.Username, "session.refresh_reuse", "session", sessionID, nil, nil)
+		clearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录凭证被重复使用，已强制下线，请重新登录"})
+		return
+	}
+
+	next, err := newRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+	now := time.Now()
+	newExpires := now.Add(h.refreshTTL())
+	_, err = tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ?", now, tokenID)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES (?, ?, ?)",
+			sessionID, hashToken(next), newExpires)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE sessions SET expires_at = ?, last_seen_at = ? WHERE id = ?", newExpires, now, sessionID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Refresh token error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+
+	access, err := middleware.GenerateToken(h.keys, user, jti, now.Add(h.accessTTL()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+		return
+	}
+	h.setAuthCookies(c, access, next)
+	c.JSON(http.StatusOK, gin.H{
+		"token":         access,
+		"refresh_token": next,
+		"expires_in":    int(h.accessTTL() / time.Second),
+	})
+}
+
+// revokeUserSessions logs a user out everywhere except the session
+// keepJTI (empty keeps none) and returns how many sessions ended.
+func (h *Handler) revokeUserSessions(userID uint, keepJTI, reason string) int64 {
+	result, err := h.db.Exec(
+		`UPDATE sessions SET revoked_at = NOW(), revoked_reason = ?
+		 WHERE user_id = ? AND jti <> ? AND revoked_at IS NULL AND expires_at > NOW()`,
+		reason, userID, keepJTI,
+	)
+	if err != nil {
+		log.Printf("Revoke sessions error: %v", err)
+		return 0
+	}
+	n, _ := result.RowsAffected()
+	return n
+}
+
+func (h *Handler) revokeSessionJTI(jti, reason string) {
+	h.db.Exec("UPDATE sessions SET revoked_at = NOW(), revoked_reason = ? WHERE jti = ? AND revoked_at IS NULL",
+		reason, jti)
+}
+
+const sessionSelect = `
+	SELECT s.id, s.jti, s.user_id, u.username, s.ip, s.user_agent, s.created_at, s.last_seen_at,
+	       s.expires_at, s.revoked_at, s.revoked_reason
+	FROM sessions s
+	JOIN users u ON u.id = s.user_id `
+
+func (h *Handler) querySessions(current, tail string, args ...interface{}) ([]model.Session, error) {
+	rows, err := h.db.Query(sessionSelect+tail, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []model.Session{}
+	for rows.Next() {
+		var s model.Session
+		var jti string
+		var revoked sql.NullTime
+		if err := rows.Scan(&s.ID, &jti, &s.UserID, &s.Username, &s.IP, &s.UserAgent, &s.CreatedAt,
+			&s.LastSeenAt, &s.ExpiresAt, &revoked, &s.RevokedReason); err != nil {
+			return nil, err
+		}
+		if revoked.Valid {
+			s.RevokedAt = &revoked.Time
+		}
+		s.Current = jti == current
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// ==================== My Sessions ====================
+
+// ListMySessions shows where the current user is logged in.
+func (h *Handler) ListMySessions(c *gin.Context) {
+	user := getUser(c)
+	list, err := h.querySessions(user.SessionID, `
+		WHERE s.user_id = ? AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		ORDER BY s.last_seen_at DESC`, user.UserID)
+	if err != nil {
+		log.Printf("List sessions error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// RevokeMySession logs out one of the current user's other devices.
+func (h *Handler) RevokeMySession(c *gin.Context) {
+	user := getUser(c)
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec(
+		"UPDATE sessions SET revoked_at = NOW(), revoked_reason = 'revoked' WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		id, user.UserID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+	h.audit(c, "session.revoke", "session", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "已下线"})
+}
+
+// LogoutEverywhere ends all of the current user's sessions. With
+// keep_current=1 the device making the request stays logged in.
+func (h *Handler) LogoutEverywhere(c *gin.Context) {
+	user := getUser(c)
+	keep := ""
+	if c.Query("keep_current") == "1" {
+		keep = user.SessionID
+	}
+	n := h.revokeUserSessions(user.UserID, keep, "logout_all")
+	h.audit(c, "session.logout_all", "user", user.UserID, nil, gin.H{"revoked": n, "kept_current": keep != ""})
+	if keep == "" {
+		clearAuthCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已退出所有设备", "revoked": n})
+}
+
+// ==================== Sessions (Admin) ====================
+
+// ListSessions returns active sessions, optionally for one user
+// (user_id=). include_revoked=1 adds revoked and expired ones.
+func (h *Handler) ListSessions(c *gin.Context) {
+	where := "WHERE 1=1"
+	args := []interface{}{}
+	if v := c.Query("user_id"); v != "" {
+		id, _ := strconv.Atoi(v)
+		if id < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效用户 ID"})
+			return
+		}
+		where += " AND s.user_id = ?"
+		args = append(args, id)
+	}
+	if c.Query("include_revoked") != "1" {
+		where += " AND s.revoked_at IS NULL AND s.expires_at > NOW()"
+	}
+	list, err := h.querySessions(getUser(c).SessionID, where+" ORDER BY s.last_seen_at DESC LIMIT 500", args...)
+	if err != nil {
+		log.Printf("List sessions error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec(
+		"UPDATE sessions SET revoked_at = NOW(), revoked_reason = 'admin' WHERE id = ? AND revoked_at IS NULL", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "操作失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+	h.audit(c, "session.revoke", "session", id, nil, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "已下线"})
+}
+
+// RevokeUserSessions logs a user out on every device.
+func (h *Handler) RevokeUserSessions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	n := h.revokeUserSessions(uint(id), "", "admin")
+	h.audit(c, "session.revoke_user", "user", id, nil, gin.H{"revoked": n})
+	c.JSON(http.StatusOK, gin.H{"message": "已强制下线", "revoked": n})
+}
+
+// ListSigningKeys shows which token keys are loaded and which one signs,
+// to check a key rotation has reached the server. Secrets are not shown.
+func (h *Handler) ListSigningKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.keys.Keys()})
+}
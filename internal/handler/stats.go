@@ -0,0 +1,438 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/perm"
+	"suv/internal/report"
+)
+
+// buildingExpr derives the building from a dorm number written as
+// 楼号-房间号 (e.g. 3-301). Dorms without a dash have no building.
+const buildingExpr = "IF(LOCATE('-', v.dorm) > 0, SUBSTRING_INDEX(v.dorm, '-', 1), '')"
+
+// dormBuilding is buildingExpr for one dorm number.
+func dormBuilding(dorm string) string {
+	building, _, found := strings.Cut(dorm, "-")
+	if !found {
+		return ""
+	}
+	return building
+}
+
+// statDimensions maps the dimension names accepted by the statistics API
+// to SQL expressions over violations v LEFT JOIN users u. Only these
+// expressions are ever placed into queries.
+var statDimensions = map[string]string{
+	"department": "v.department",
+	"period":     "v.period",
+	"class":      "v.class_name",
+	"dorm":       "v.dorm",
+	"building":   buildingExpr,
+	"category":   "v.category",
+	"inspector":  "v.inspector",
+	"creator":    "COALESCE(u.display_name, u.username)",
+}
+
+var statIntervals = map[string]string{
+	"day":   "DATE_FORMAT(v.created_at, '%Y-%m-%d')",
+	"week":  "DATE_FORMAT(DATE_SUB(DATE(v.created_at), INTERVAL WEEKDAY(v.created_at) DAY), '%Y-%m-%d')",
+	"month": "DATE_FORMAT(v.created_at, '%Y-%m')",
+}
+
+type statGroup struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+type statSeries struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+	Data  []int  `json:"data"`
+}
+
+// StatsPage renders the charts page fed by GetStatsBreakdown.
+func (h *Handler) StatsPage(c *gin.Context) {
+	user := getUser(c)
+	c.HTML(http.StatusOK, "stats.html", gin.H{
+		"user":       user,
+		"csrf_token": getCSRF(c),
+	})
+}
+
+// GetStatsBreakdown groups violation counts over a date range.
+//
+//	by=class            counts per dimension value (see statDimensions)
+//	interval=day        time series by day, week or month
+//	by=...&interval=... one series per dimension value (top `limit`)
+//
+// Any dimension name may also be passed as a filter, e.g. department=纪检部.
+func (h *Handler) GetStatsBreakdown(c *gin.Context) {
+	where, args, start, end, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	by := c.Query("by")
+	dimExpr, ok := statDimensions[by]
+	if by != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的统计维度"})
+		return
+	}
+	interval := c.Query("interval")
+	bucketExpr, ok := statIntervals[interval]
+	if interval != "" && !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的时间粒度"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	const from = " FROM violations v LEFT JOIN users u ON v.created_by = u.id "
+
+	var total int
+	if err := h.db.QueryRow("SELECT COUNT(*)"+from+where, args...).Scan(&total); err != nil {
+		log.Printf("Stats total error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	resp := gin.H{
+		"start":    start.Format("2006-01-02"),
+		"end":      end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"by":       by,
+		"interval": interval,
+		"total":    total,
+	}
+
+	var groups []statGroup
+	if by != "" {
+		rows, err := h.db.Query(
+			"SELECT "+dimExpr+" AS k, COUNT(*) AS n"+from+where+" GROUP BY k ORDER BY n DESC, k LIMIT ?",
+			append(args, limit)...)
+		if err != nil {
+			log.Printf("Stats groups error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		defer rows.Close()
+		groups = []statGroup{}
+		for rows.Next() {
+			var g statGroup
+			if err := rows.Scan(&g.Key, &g.Count); err != nil {
+				continue
+			}
+			groups = append(groups, g)
+		}
+		resp["groups"] = groups
+	}
+
+	if interval != "" {
+		labels := bucketLabels(interval, start, end)
+		index := make(map[string]int, len(labels))
+		for i, l := range labels {
+			index[l] = i
+		}
+
+		// One series per group, in the same order; without a dimension
+		// there is a single series for everything.
+		series := []*statSeries{}
+		byKey := map[string]*statSeries{}
+		keyExpr := "''"
+		if by != "" {
+			keyExpr = dimExpr
+			for _, g := range groups {
+				s := &statSeries{Key: g.Key, Count: g.Count, Data: make([]int, len(labels))}
+				series = append(series, s)
+				byKey[g.Key] = s
+			}
+		} else {
+			s := &statSeries{Key: "全部", Count: total, Data: make([]int, len(labels))}
+			series = append(series, s)
+			byKey[""] = s
+		}
+
+		rows, err := h.db.Query(
+			"SELECT "+keyExpr+" AS k, "+bucketExpr+" AS b, COUNT(*)"+from+where+" GROUP BY k, b", args...)
+		if err != nil {
+			log.Printf("Stats series error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var k, b string
+			var n int
+			if err := rows.Scan(&k, &b, &n); err != nil {
+				continue
+			}
+			s, ok := byKey[k]
+			i, ok2 := index[b]
+			if ok && ok2 {
+				s.Data[i] = n
+			}
+		}
+
+		resp["labels"] = labels
+		resp["series"] = series
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// periodOrder is the order periods are offered in on the record page;
+// anything else recorded is appended alphabetically.
+var periodOrder = []string{"早操", "课间操", "上午", "中午", "午休", "下午", "晚休", "晚自习"}
+
+var weekdayNames = []string{"周一", "周二", "周三", "周四", "周五", "周六", "周日"}
+
+type deptTrend struct {
+	Department   string   `json:"department"`
+	WeekCurrent  int      `json:"week_current"`
+	WeekPrevious int      `json:"week_previous"`
+	WeekDelta    int      `json:"week_delta"`
+	WeekChange   *float64 `json:"week_change"` // percent, null when previous is 0
+	TermCurrent  int      `json:"term_current"`
+	TermPrevious int      `json:"term_previous"`
+	TermDelta    int      `json:"term_delta"`
+	TermChange   *float64 `json:"term_change"`
+}
+
+// GetStatsHeatmap returns a weekday × period matrix of counts for the date
+// range, plus per-department week-over-week and term-over-term deltas as of
+// the range's last day. Comparisons are like for like: this week so far
+// against the same weekdays of last week, and this term so far against the
+// same number of days into the previous term.
+func (h *Handler) GetStatsHeatmap(c *gin.Context) {
+	where, args, start, end, err := statsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := h.db.Query(
+		"SELECT WEEKDAY(v.created_at) AS wd, v.period, COUNT(*)"+
+			" FROM violations v LEFT JOIN users u ON v.created_by = u.id "+where+" GROUP BY wd, v.period", args...)
+	if err != nil {
+		log.Printf("Stats heatmap error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	counts := map[string][7]int{}
+	total := 0
+	for rows.Next() {
+		var wd, n int
+		var period string
+		if err := rows.Scan(&wd, &period, &n); err != nil || wd < 0 || wd > 6 {
+			continue
+		}
+		row := counts[period]
+		row[wd] += n
+		counts[period] = row
+		total += n
+	}
+
+	periods := []string{}
+	for _, p := range periodOrder {
+		if _, ok := counts[p]; ok {
+			periods = append(periods, p)
+		}
+	}
+	extra := []string{}
+	for p := range counts {
+		if !slices.Contains(periodOrder, p) {
+			extra = append(extra, p)
+		}
+	}
+	sort.Strings(extra)
+	periods = append(periods, extra...)
+
+	// matrix[weekday][period]
+	matrix := make([][]int, 7)
+	for wd := range matrix {
+		matrix[wd] = make([]int, len(periods))
+		for i, p := range periods {
+			matrix[wd][i] = counts[p][wd]
+		}
+	}
+
+	ref := end.AddDate(0, 0, -1)
+	trends, err := h.departmentTrends(c, ref)
+	if err != nil {
+		log.Printf("Stats trends error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	trends["date"] = ref.Format("2006-01-02")
+
+	c.JSON(http.StatusOK, gin.H{
+		"start":    start.Format("2006-01-02"),
+		"end":      ref.Format("2006-01-02"),
+		"total":    total,
+		"weekdays": weekdayNames,
+		"periods":  periods,
+		"matrix":   matrix,
+		"trends":   trends,
+	})
+}
+
+func (h *Handler) departmentTrends(c *gin.Context, ref time.Time) (gin.H, error) {
+	dayAfter := report.Day(ref).AddDate(0, 0, 1)
+
+	weekStart := report.WeekStart(ref)
+	prevWeekStart := weekStart.AddDate(0, 0, -7)
+	prevWeekEnd := dayAfter.AddDate(0, 0, -7)
+
+	termStart, prevTermStart := report.TermStart(ref, h.cfg.TermStarts)
+	elapsed := int(dayAfter.Sub(termStart).Hours()+12) / 24
+	prevTermEnd := prevTermStart.AddDate(0, 0, elapsed)
+	if prevTermEnd.After(termStart) {
+		prevTermEnd = termStart
+	}
+
+	ranges := [4][2]time.Time{
+		{weekStart, dayAfter}, {prevWeekStart, prevWeekEnd},
+		{termStart, dayAfter}, {prevTermStart, prevTermEnd},
+	}
+	byDept := map[string]*deptTrend{}
+	cond, condArgs := dimensionFilter(c)
+	for i, r := range ranges {
+		rows, err := h.db.Query(
+			"SELECT v.department, COUNT(*) FROM violations v LEFT JOIN users u ON v.created_by = u.id"+
+				" WHERE v.created_at >= ? AND v.created_at < ?"+cond+" GROUP BY v.department",
+			append([]interface{}{r[0], r[1]}, condArgs...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var dept string
+			var n int
+			if err := rows.Scan(&dept, &n); err != nil {
+				continue
+			}
+			t := byDept[dept]
+			if t == nil {
+				t = &deptTrend{Department: dept}
+				byDept[dept] = t
+			}
+			switch i {
+			case 0:
+				t.WeekCurrent = n
+			case 1:
+				t.WeekPrevious = n
+			case 2:
+				t.TermCurrent = n
+			case 3:
+				t.TermPrevious = n
+			}
+		}
+		rows.Close()
+	}
+
+	list := []deptTrend{}
+	for _, t := range byDept {
+		t.WeekDelta = t.WeekCurrent - t.WeekPrevious
+		t.WeekChange = percentChange(t.WeekCurrent, t.WeekPrevious)
+		t.TermDelta = t.TermCurrent - t.TermPrevious
+		t.TermChange = percentChange(t.TermCurrent, t.TermPrevious)
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Department < list[j].Department })
+
+	span := func(a, b time.Time) gin.H {
+		return gin.H{"start": a.Format("2006-01-02"), "end": b.AddDate(0, 0, -1).Format("2006-01-02")}
+	}
+	return gin.H{
+		"week":        gin.H{"current": span(weekStart, dayAfter), "previous": span(prevWeekStart, prevWeekEnd)},
+		"term":        gin.H{"current": span(termStart, dayAfter), "previous": span(prevTermStart, prevTermEnd)},
+		"departments": list,
+	}, nil
+}
+
+func percentChange(cur, prev int) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	p := math.Round(float64(cur-prev)/float64(prev)*1000) / 10
+	return &p
+}
+
+// statsFilter builds the WHERE clause shared by the statistics endpoints:
+// the date range plus an equality filter for every dimension given in the
+// query string.
+func statsFilter(c *gin.Context) (string, []interface{}, time.Time, time.Time, error) {
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		return "", nil, start, end, err
+	}
+
+	cond, args := dimensionFilter(c)
+	return "WHERE v.created_at >= ? AND v.created_at < ?" + cond,
+		append([]interface{}{start, end}, args...), start, end, nil
+}
+
+// dimensionFilter returns " AND dim = ?" conditions for every dimension
+// given in the query string, limited to the records the user may read.
+func dimensionFilter(c *gin.Context) (string, []interface{}) {
+	cond, args := scopeFor(c, perm.ViolationRead).where()
+	if args == nil {
+		args = []interface{}{}
+	}
+	for name, expr := range statDimensions {
+		if val, ok := c.GetQuery(name); ok {
+			cond += fmt.Sprintf(" AND %s = ?", expr)
+			args = append(args, val)
+		}
+	}
+	return cond, args
+}
+
+// bucketLabels lists every bucket between start and end in the same format
+// the statIntervals expressions produce, so empty periods show up as zero.
+func bucketLabels(interval string, start, end time.Time) []string {
+	labels := []string{}
+	switch interval {
+	case "day":
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			labels = append(labels, d.Format("2006-01-02"))
+		}
+	case "week":
+		for d := report.WeekStart(start); d.Before(end); d = d.AddDate(0, 0, 7) {
+			labels = append(labels, d.Format("2006-01-02"))
+		}
+	case "month":
+		for d := start.AddDate(0, 0, 1-start.Day()); d.Before(end); d = d.AddDate(0, 1, 0) {
+			labels = append(labels, d.Format("2006-01"))
+		}
+	}
+	return labels
+}
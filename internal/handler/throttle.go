@@ -0,0 +1,281 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/config"
+	"suv/internal/model"
+)
+
+// Failed logins are counted per username and per client IP in
+// login_throttle. A row is locked once it reaches its threshold; the lock
+// doubles with every failure after that. Counters older than the window
+// start over, and a successful login clears the username's counter (not
+// the IP's, or one valid account would let an attacker keep guessing).
+//
+// Attempts are counted before the password is checked, so concurrent
+// guesses cannot all pass the lock check and then be recorded too late; a
+// successful login takes its attempt back off the IP counter. Attempts are
+// only refused while a lock is in effect: once it has expired the next
+// attempt gets its password checked, and only escalates the lock if it
+// fails.
+
+// loginIP is the address failed logins are counted against. Forwarded
+// headers are only believed from TRUSTED_PROXIES, otherwise every request
+// could claim a fresh address and never reach the IP limit.
+func (h *Handler) loginIP(c *gin.Context) string {
+	remote := c.RemoteIP()
+	if !h.trustedProxy(remote) {
+		return remote
+	}
+	// The rightmost address not added by one of our proxies is the client.
+	hops := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(hops[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !h.trustedProxy(ip) {
+			return ip
+		}
+	}
+	return remote
+}
+
+func (h *Handler) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, p := range h.cfg.TrustedProxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(p); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginLockedFor returns how long the username or IP is still locked out.
+func (h *Handler) loginLockedFor(username, ip string) time.Duration {
+	var until sql.NullTime
+	h.db.QueryRow(
+		`SELECT MAX(locked_until) FROM login_throttle
+		 WHERE (scope = 'user' AND subject = ?) OR (scope = 'ip' AND subject = ?)`,
+		strings.ToLower(username), ip,
+	).Scan(&until)
+	if !until.Valid {
+		return 0
+	}
+	if d := time.Until(until.Time); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// countLoginAttempt counts an attempt against the username and the IP
+// before its password is checked. It returns the lockout the attempt
+// triggers if it fails and whether that includes the IP counter, or wait
+// when a lock is still in effect, in which case the attempt is refused
+// without checking the password.
+func (h *Handler) countLoginAttempt(username, ip string) (lock, wait time.Duration, ipLocked bool) {
+	d1, w := h.bumpThrottle("user", strings.ToLower(username), h.cfg.LoginMaxFailures)
+	if w > 0 {
+		return 0, w, false
+	}
+	d2, w := h.bumpThrottle("ip", ip, h.cfg.LoginIPMaxFailures)
+	if w > 0 {
+		return 0, w, false
+	}
+	return max(d1, d2), 0, d2 > 0
+}
+
+// bumpThrottle applies one attempt to a counter (see throttleState.attempt)
+// and returns the lock it set, or how long an existing lock still runs.
+func (h *Handler) bumpThrottle(scope, subject string, limit int) (lock, wait time.Duration) {
+	if subject == "" || limit <= 0 {
+		return 0, 0
+	}
+	tx, err := h.db.Begin()
+	if err != nil {
+		return 0, 0
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var t throttleState
+	var until sql.NullTime
+	err = tx.QueryRow("SELECT failures, last_failure_at, locked_until FROM login_throttle WHERE scope = ? AND subject = ? FOR UPDATE",
+		scope, subject).Scan(&t.failures, &t.last, &until)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Login throttle error: %v", err)
+		return 0, 0
+	}
+	t.lockedUntil = until.Time
+
+	lock, wait = t.attempt(now, limit, h.cfg)
+	if wait > 0 {
+		return 0, wait
+	}
+	var lockedUntil interface{}
+	if lock > 0 {
+		lockedUntil = t.lockedUntil
+	}
+
+	_, err = tx.Exec(`INSERT INTO login_throttle (scope, subject, failures, last_failure_at, locked_until)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE failures = VALUES(failures), last_failure_at = VALUES(last_failure_at),
+		                        locked_until = VALUES(locked_until)`,
+		scope, subject, t.failures, now, lockedUntil)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Login throttle error: %v", err)
+		return 0, 0
+	}
+	return lock, 0
+}
+
+// throttleState is one login_throttle row.
+type throttleState struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time // zero when never locked
+}
+
+// attempt applies a login attempt made at now. While a lock is in effect
+// the attempt is refused with the time left and nothing changes. Otherwise
+// it counts as a failure up front (the window starting over if the last one
+// is too old) and the lock it sets once limit is reached is returned.
+func (t *throttleState) attempt(now time.Time, limit int, cfg *config.Config) (lock, wait time.Duration) {
+	if d := t.lockedUntil.Sub(now); d > 0 {
+		return 0, d
+	}
+	if now.Sub(t.last) > time.Duration(cfg.LoginWindow)*time.Minute {
+		t.failures = 0
+	}
+	t.failures++
+	t.last = now
+	t.lockedUntil = time.Time{}
+	if t.failures >= limit {
+		lock = lockDuration(t.failures-limit, cfg.LoginLockBase, cfg.LoginLockMax)
+		t.lockedUntil = now.Add(lock)
+	}
+	return lock, 0
+}
+
+// lockDuration is base minutes doubled n times, capped at max minutes.
+func lockDuration(n, base, max int) time.Duration {
+	d := time.Duration(base) * time.Minute
+	limit := time.Duration(max) * time.Minute
+	for i := 0; i < n && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// clearLoginFailures resets the username's counter after a successful
+// login and takes the attempt back off the IP's counter, lifting the IP
+// lock if the attempt set it.
+func (h *Handler) clearLoginFailures(username, ip string, ipLocked bool) {
+	h.db.Exec("DELETE FROM login_throttle WHERE scope = 'user' AND subject = ?", strings.ToLower(username))
+	h.db.Exec(`UPDATE login_throttle SET failures = GREATEST(failures - 1, 0),
+		locked_until = IF(?, NULL, locked_until)
+		WHERE scope = 'ip' AND subject = ?`, ipLocked, ip)
+}
+
+// rejectLocked answers a login attempt made during a lockout.
+func rejectLocked(c *gin.Context, d time.Duration) {
+	secs := int((d + time.Second - 1) / time.Second)
+	wait := strconv.Itoa(secs) + " 秒"
+	if secs >= 60 {
+		wait = strconv.Itoa((secs+59)/60) + " 分钟"
+	}
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "登录失败次数过多，请 " + wait + "后再试",
+		"retry_after": secs,
+	})
+}
+
+// ==================== Login Locks ====================
+
+// ListLoginLocks shows the usernames and IPs with recent failures, locked
+// ones first.
+func (h *Handler) ListLoginLocks(c *gin.Context) {
+	rows, err := h.db.Query(`
+		SELECT id, scope, subject, failures, last_failure_at, locked_until
+		FROM login_throttle
+		ORDER BY (locked_until > NOW()) DESC, last_failure_at DESC
+		LIMIT 500
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	list := []model.LoginThrottle{}
+	for rows.Next() {
+		var t model.LoginThrottle
+		var until sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Scope, &t.Subject, &t.Failures, &t.LastFailureAt, &until); err != nil {
+			continue
+		}
+		if until.Valid {
+			t.LockedUntil = &until.Time
+			t.Locked = until.Time.After(now)
+		}
+		list = append(list, t)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// UnlockLogin clears a username's or IP's failure counter.
+func (h *Handler) UnlockLogin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效 ID"})
+		return
+	}
+	result, err := h.db.Exec("DELETE FROM login_throttle WHERE id = ?", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "解锁失败"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已解锁"})
+}
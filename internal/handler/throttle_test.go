@@ -0,0 +1,100 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"suv/internal/config"
+)
+
+func TestThrottleLockExpiry(t *testing.T) {
+	cfg := &config.Config{LoginWindow: 15, LoginLockBase: 1, LoginLockMax: 60}
+	const limit = 5
+	now := time.Date(2025, 3, 5, 10, 0, 0, 0, time.UTC)
+	var st throttleState
+
+	// Five failures: the fifth sets the base lock.
+	for i := 1; i <= limit; i++ {
+		lock, wait := st.attempt(now, limit, cfg)
+		if wait != 0 {
+			t.Fatalf("attempt %d refused", i)
+		}
+		want := time.Duration(0)
+		if i == limit {
+			want = time.Minute
+		}
+		if lock != want {
+			t.Fatalf("attempt %d: lock %v, want %v", i, lock, want)
+		}
+		now = now.Add(time.Second)
+	}
+
+	// During the lock attempts are refused and not counted.
+	if _, wait := st.attempt(now, limit, cfg); wait <= 0 {
+		t.Fatal("attempt during the lock was not refused")
+	}
+	if st.failures != limit {
+		t.Fatalf("refused attempt was counted: failures = %d", st.failures)
+	}
+
+	// Once the lock has expired, still inside the window, the next attempt
+	// reaches the password check. It only locks again (for longer) should
+	// the password turn out wrong.
+	now = now.Add(time.Minute)
+	lock, wait := st.attempt(now, limit, cfg)
+	if wait != 0 {
+		t.Fatalf("attempt after the lock expired refused for %v", wait)
+	}
+	if lock != 2*time.Minute {
+		t.Fatalf("lock on a further failure = %v, want 2m", lock)
+	}
+
+	// The correct password clears the username's counter, after which
+	// logins work as normal.
+	st = throttleState{}
+	if lock, wait := st.attempt(now, limit, cfg); lock != 0 || wait != 0 {
+		t.Fatalf("attempt after a successful login: lock %v, wait %v", lock, wait)
+	}
+}
+
+func TestThrottleWindow(t *testing.T) {
+	cfg := &config.Config{LoginWindow: 15, LoginLockBase: 1, LoginLockMax: 60}
+	now := time.Date(2025, 3, 5, 10, 0, 0, 0, time.UTC)
+	st := throttleState{failures: 4, last: now.Add(-16 * time.Minute)}
+	if lock, _ := st.attempt(now, 5, cfg); lock != 0 || st.failures != 1 {
+		t.Fatalf("stale counter not reset: failures %d, lock %v", st.failures, lock)
+	}
+}
+
+func TestLockDuration(t *testing.T) {
+	tests := []struct {
+		n, base, max int
+		want         time.Duration
+	}{
+		{0, 1, 60, time.Minute},
+		{1, 1, 60, 2 * time.Minute},
+		{3, 1, 60, 8 * time.Minute},
+		{10, 1, 60, 60 * time.Minute},
+		{2, 5, 15, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := lockDuration(tt.n, tt.base, tt.max); got != tt.want {
+			t.Errorf("lockDuration(%d, %d, %d) = %v, want %v", tt.n, tt.base, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"suv/internal/report"
+)
+
+type userWorkload struct {
+	UserID       uint           `json:"user_id"`
+	Username     string         `json:"username"`
+	DisplayName  string         `json:"display_name"`
+	Role         string         `json:"role"`
+	Records      int            `json:"records"`
+	ActiveDays   int            `json:"active_days"`
+	PerDay       float64        `json:"per_day"` // records per calendar day in the range
+	WithPhoto    int            `json:"with_photo"`
+	PhotoShare   float64        `json:"photo_share"` // percent
+	Revised      int            `json:"revised"`
+	RevisionRate float64        `json:"revision_rate"` // percent
+	Deleted      int            `json:"deleted"`
+	DeletionRate float64        `json:"deletion_rate"` // percent of records entered
+	ByPeriod     map[string]int `json:"by_period"`
+	LastRecordAt *time.Time     `json:"last_record_at"`
+}
+
+type inspectorWorkload struct {
+	Inspector  string         `json:"inspector"`
+	Records    int            `json:"records"`
+	ActiveDays int            `json:"active_days"`
+	PerDay     float64        `json:"per_day"`
+	WithPhoto  int            `json:"with_photo"`
+	PhotoShare float64        `json:"photo_share"`
+	Deleted    int            `json:"deleted"`
+	ByPeriod   map[string]int `json:"by_period"`
+}
+
+type inactiveUser struct {
+	UserID       uint       `json:"user_id"`
+	Username     string     `json:"username"`
+	DisplayName  string     `json:"display_name"`
+	LastRecordAt *time.Time `json:"last_record_at"`
+}
+
+// GetWorkloadStats reports patrol activity per account (created_by) and
+// per inspector name over a date range. Accounts that have recorded
+// nothing in the last inactive_days days (default 14, counted back from
+// today regardless of the range) are listed as inactive. Admin only.
+func (h *Handler) GetWorkloadStats(c *gin.Context) {
+	start, end, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	days := float64(int(end.Sub(start).Hours()+12) / 24)
+
+	users, err := h.userWorkload(start, end, days)
+	if err != nil {
+		log.Printf("Workload users error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+	inspectors, err := h.inspectorWorkload(start, end, days)
+	if err != nil {
+		log.Printf("Workload inspectors error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		return
+	}
+
+	inactiveDays, _ := strconv.Atoi(c.DefaultQuery("inactive_days", "14"))
+	if inactiveDays < 1 || inactiveDays > 366 {
+		inactiveDays = 14
+	}
+	since := report.Day(time.Now()).AddDate(0, 0, 1-inactiveDays)
+
+	inactive := []inactiveUser{}
+	for _, u := range users {
+		if u.LastRecordAt == nil || u.LastRecordAt.Before(since) {
+			inactive = append(inactive, inactiveUser{u.UserID, u.Username, u.DisplayName, u.LastRecordAt})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"start":         start.Format("2006-01-02"),
+		"end":           end.AddDate(0, 0, -1).Format("2006-01-02"),
+		"users":         users,
+		"inspectors":    inspectors,
+		"inactive":      inactive,
+		"inactive_days": inactiveDays,
+	})
+}
+
+func (h *Handler) userWorkload(start, end time.Time, days float64) ([]*userWorkload, error) {
+	byID := map[uint]*userWorkload{}
+	list := []*userWorkload{}
+
+	rows, err := h.db.Query(`
+		SELECT u.id, u.username, u.display_name, u.role, MAX(v.created_at)
+		FROM users u
+		LEFT JOIN violations v ON v.created_by = u.id
+		GROUP BY u.id, u.username, u.display_name, u.role
+		ORDER BY u.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		w := &userWorkload{ByPeriod: map[string]int{}}
+		var last sql.NullTime
+		if err := rows.Scan(&w.UserID, &w.Username, &w.DisplayName, &w.Role, &last); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if last.Valid {
+			w.LastRecordAt = &last.Time
+		}
+		byID[w.UserID] = w
+		list = append(list, w)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT created_by, period, COUNT(*), SUM(photo_path <> ''), SUM(revision_count > 0)
+		FROM violations
+		WHERE created_at >= ? AND created_at < ?
+		GROUP BY created_by, period
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uint
+		var period string
+		var n, photos, revised int
+		if err := rows.Scan(&id, &period, &n, &photos, &revised); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if w := byID[id]; w != nil {
+			w.Records += n
+			w.WithPhoto += photos
+			w.Revised += revised
+			w.ByPeriod[period] += n
+		}
+	}
+	rows.Close()
+
+	// Distinct active days need their own query; summing per period would
+	// count a day once for every period patrolled.
+	if err := h.scanCounts(`
+		SELECT created_by, COUNT(DISTINCT DATE(created_at)) FROM violations
+		WHERE created_at >= ? AND created_at < ? GROUP BY created_by
+	`, start, end, func(id uint, n int) {
+		if w := byID[id]; w != nil {
+			w.ActiveDays = n
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if err := h.scanCounts(`
+		SELECT created_by, COUNT(*) FROM violation_deletions
+		WHERE created_at >= ? AND created_at < ? GROUP BY created_by
+	`, start, end, func(id uint, n int) {
+		if w := byID[id]; w != nil {
+			w.Deleted = n
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, w := range list {
+		entered := w.Records + w.Deleted
+		w.PerDay = round1(float64(w.Records) / days)
+		w.PhotoShare = percent(w.WithPhoto, w.Records)
+		w.RevisionRate = percent(w.Revised, w.Records)
+		w.DeletionRate = percent(w.Deleted, entered)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Records > list[j].Records })
+	return list, nil
+}
+
+func (h *Handler) inspectorWorkload(start, end time.Time, days float64) ([]*inspectorWorkload, error) {
+	byName := map[string]*inspectorWorkload{}
+	get := func(name string) *inspectorWorkload {
+		w := byName[name]
+		if w == nil {
+			w = &inspectorWorkload{Inspector: name, ByPeriod: map[string]int{}}
+			byName[name] = w
+		}
+		return w
+	}
+
+	rows, err := h.db.Query(`
+		SELECT inspector, period, COUNT(*), SUM(photo_path <> '')
+		FROM violations
+		WHERE created_at >= ? AND created_at < ?
+		GROUP BY inspector, period
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name, period string
+		var n, photos int
+		if err := rows.Scan(&name, &period, &n, &photos); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		w := get(name)
+		w.Records += n
+		w.WithPhoto += photos
+		w.ByPeriod[period] += n
+	}
+	rows.Close()
+
+	for _, q := range []struct {
+		sql string
+		set func(*inspectorWorkload, int)
+	}{
+		{`SELECT inspector, COUNT(DISTINCT DATE(created_at)) FROM violations
+		  WHERE created_at >= ? AND created_at < ? GROUP BY inspector`,
+			func(w *inspectorWorkload, n int) { w.ActiveDays = n }},
+		{`SELECT inspector, COUNT(*) FROM violation_deletions
+		  WHERE created_at >= ? AND created_at < ? GROUP BY inspector`,
+			func(w *inspectorWorkload, n int) { w.Deleted = n }},
+	} {
+		rows, err := h.db.Query(q.sql, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			var n int
+			if err := rows.Scan(&name, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			q.set(get(name), n)
+		}
+		rows.Close()
+	}
+
+	list := make([]*inspectorWorkload, 0, len(byName))
+	for _, w := range byName {
+		w.PerDay = round1(float64(w.Records) / days)
+		w.PhotoShare = percent(w.WithPhoto, w.Records)
+		list = append(list, w)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Records != list[j].Records {
+			return list[i].Records > list[j].Records
+		}
+		return list[i].Inspector < list[j].Inspector
+	})
+	return list, nil
+}
+
+// scanCounts runs a (user id, count) query over [start, end).
+func (h *Handler) scanCounts(query string, start, end time.Time, fn func(uint, int)) error {
+	rows, err := h.db.Query(query, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		fn(id, n)
+	}
+	return rows.Err()
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return round1(float64(part) * 100 / float64(whole))
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package live fans violation changes out to the public display streams.
+package live
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"suv/internal/model"
+)
+
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+// Event is one change. Violation is the full record, nil for deletions;
+// streams project it before sending.
+type Event struct {
+	ID          string
+	Type        string
+	ViolationID uint
+	Violation   *model.Violation
+
+	seq uint64
+}
+
+// Broker keeps the last few events so a reconnecting client can resume
+// from its Last-Event-ID. Event ids carry the broker's start time so ids
+// from before a restart are recognised as unknown instead of replaying the
+// wrong events.
+type Broker struct {
+	mu     sync.Mutex
+	epoch  string
+	seq    uint64
+	buf    []Event // ring of the most recent events, oldest first
+	size   int
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+func NewBroker(size int) *Broker {
+	return &Broker{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  size,
+		subs:  map[chan Event]struct{}{},
+	}
+}
+
+// Publish records the event and hands it to every subscriber. Slow
+// subscribers are dropped rather than blocking the request that caused the
+// change; their client reconnects and resumes.
+func (b *Broker) Publish(typ string, id uint, v *model.Violation) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e := Event{ID: fmt.Sprintf("%s-%d", b.epoch, b.seq), Type: typ, ViolationID: id, Violation: v, seq: b.seq}
+	b.buf = append(b.buf, e)
+	if len(b.buf) > b.size {
+		b.buf = b.buf[len(b.buf)-b.size:]
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe registers a listener. If lastID is set, the events after it are
+// returned for replay; ok is false when lastID is from another run or too
+// old for the buffer, and the client has to reload the full list.
+func (b *Broker) Subscribe(lastID string) (ch chan Event, replay []Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch = make(chan Event, 32)
+	if !b.closed {
+		b.subs[ch] = struct{}{}
+	} else {
+		close(ch)
+	}
+
+	if lastID == "" {
+		return ch, nil, true
+	}
+	epoch, seqStr, found := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if !found || err != nil || epoch != b.epoch || seq > b.seq {
+		return ch, nil, false
+	}
+	if seq == b.seq {
+		return ch, nil, true
+	}
+	if len(b.buf) == 0 || b.buf[0].seq > seq+1 {
+		return ch, nil, false
+	}
+	for _, e := range b.buf {
+		if e.seq > seq {
+			replay = append(replay, e)
+		}
+	}
+	return ch, replay, true
+}
+
+func (b *Broker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Close ends every stream, e.g. on shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// LastID is the id of the newest event, sent to fresh clients so a
+// reconnect right after loading the list does not miss anything.
+func (b *Broker) LastID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fmt.Sprintf("%s-%d", b.epoch, b.seq)
+}
@@ -0,0 +1,254 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package metrics keeps process-wide counters and histograms and writes
+// them in the Prometheus text exposition format. It is deliberately small:
+// no client library, just what the server needs.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	HTTPRequests = NewCounter("suv_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "status")
+	HTTPDuration = NewHistogram("suv_http_request_duration_seconds",
+		"HTTP request latency by route and method.",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "route", "method")
+
+	UploadBytes = NewHistogram("suv_upload_bytes",
+		"Size of accepted photo uploads.",
+		[]float64{64 << 10, 256 << 10, 512 << 10, 1 << 20, 2 << 20, 3 << 20, 4 << 20, 5 << 20})
+	UploadFailures = NewCounter("suv_upload_failures_total",
+		"Rejected or failed photo uploads by reason.", "reason")
+
+	Logins = NewCounter("suv_logins_total",
+		"Login attempts by result.", "result")
+)
+
+var (
+	mu       sync.Mutex
+	families []family
+)
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+func register(f family) {
+	mu.Lock()
+	families = append(families, f)
+	mu.Unlock()
+}
+
+// Counter is a monotonically increasing value per label combination.
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one. values must match the label names given to NewCounter.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	key := labelString(c.labels, values)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		sample(w, c.name, key, c.values[key])
+	}
+}
+
+// Histogram counts observations into cumulative buckets per label
+// combination.
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histSeries
+}
+
+type histSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histSeries{}}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := labelString(h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	header(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cum uint64
+		for i, b := range h.buckets {
+			cum += s.counts[i]
+			sample(w, h.name+"_bucket", withLabel(key, "le", formatValue(b)), float64(cum))
+		}
+		sample(w, h.name+"_bucket", withLabel(key, "le", "+Inf"), float64(s.count))
+		sample(w, h.name+"_sum", key, s.sum)
+		sample(w, h.name+"_count", key, float64(s.count))
+	}
+}
+
+// Gauge is a value read at scrape time, e.g. from the database.
+type Gauge struct {
+	Name, Help string
+	Samples    []Sample
+}
+
+type Sample struct {
+	Labels []string // alternating name, value
+	Value  float64
+}
+
+// Write writes every registered family followed by the given gauges.
+func Write(out io.Writer, gauges ...Gauge) error {
+	w := bufio.NewWriter(out)
+	mu.Lock()
+	list := append([]family(nil), families...)
+	mu.Unlock()
+	for _, f := range list {
+		f.write(w)
+	}
+	for _, g := range gauges {
+		header(w, g.Name, g.Help, "gauge")
+		for _, s := range g.Samples {
+			var names, values []string
+			for i := 0; i+1 < len(s.Labels); i += 2 {
+				names = append(names, s.Labels[i])
+				values = append(values, s.Labels[i+1])
+			}
+			sample(w, g.Name, labelString(names, values), s.Value)
+		}
+	}
+	return w.Flush()
+}
+
+func header(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sample(w *bufio.Writer, name, labels string, v float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatValue(v) + "\n")
+}
+
+// labelString renders name="value" pairs; it doubles as the series key.
+func labelString(names, values []string) string {
+	var b strings.Builder
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n + `="` + escape(v) + `"`)
+	}
+	return b.String()
+}
+
+func withLabel(labels, name, value string) string {
+	l := name + `="` + value + `"`
+	if labels == "" {
+		return l
+	}
+	return labels + "," + l
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// A Keyring holds the keys access tokens are signed and verified with.
+// Every token names its key in the kid header and is only accepted with
+// that key's algorithm, so an HS256 secret can never be used to verify a
+// token claiming another algorithm (or the other way round).
+//
+// Rotation: add the new key to JWT_KEYS and restart; switch JWT_SIGNING_KEY
+// to it; once the access token lifetime has passed, drop the old key.
+// Refresh tokens are not JWTs, so none of these steps logs anyone out.
+type Keyring struct {
+	keys    map[string]*signingKey
+	signing *signingKey
+	methods []string
+}
+
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{} // nil for verify-only keys
+	verify interface{}
+}
+
+// KeyInfo describes a key without revealing it.
+type KeyInfo struct {
+	ID      string `json:"kid"`
+	Alg     string `json:"alg"`
+	Signing bool   `json:"signing"`
+	CanSign bool   `json:"can_sign"`
+}
+
+// NewKeyring builds the keyring from the JWT_KEYS spec, a comma-separated
+// list of kid=type:value entries:
+//
+//	hs256:<secret>               shared secret
+//	ed25519:<file>               PEM PKCS#8 private key (signs and verifies)
+//	ed25519-pub:<file>           PEM public key (verifies only)
+//
+// signingKID picks the key for new tokens and defaults to the first entry.
+// An empty spec means a single HS256 key "default" made from secret
+// (JWT_SECRET), which is how older installs keep working.
+func NewKeyring(secret, spec, signingKID string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]*signingKey{}}
+	first := ""
+
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = "default=hs256:" + secret
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, def, ok := strings.Cut(entry, "=")
+		typ, value, ok2 := strings.Cut(def, ":")
+		kid = strings.TrimSpace(kid)
+		if !ok || !ok2 || kid == "" || value == "" {
+			return nil, fmt.Errorf("JWT_KEYS: invalid entry %q, want kid=type:value", kid)
+		}
+		if _, dup := k.keys[kid]; dup {
+			return nil, fmt.Errorf("JWT_KEYS: duplicate kid %q", kid)
+		}
+		key, err := loadKey(kid, strings.ToLower(strings.TrimSpace(typ)), value)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[kid] = key
+		if first == "" {
+			first = kid
+		}
+	}
+	if first == "" {
+		return nil, fmt.Errorf("JWT_KEYS: no keys")
+	}
+
+	if signingKID == "" {
+		signingKID = first
+	}
+	k.signing = k.keys[signingKID]
+	if k.signing == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY: unknown kid %q", signingKID)
+	}
+	if k.signing.sign == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY: key %q can only verify", signingKID)
+	}
+
+	seen := map[string]bool{}
+	for _, key := range k.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			k.methods = append(k.methods, alg)
+		}
+	}
+	sort.Strings(k.methods)
+	return k, nil
+}
+
+func loadKey(kid, typ, value string) (*signingKey, error) {
+	switch typ {
+	case "hs256":
+		if len(value) < 32 {
+			log.Printf("JWT key %q is shorter than 32 bytes; use a longer random secret", kid)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodHS256, sign: []byte(value), verify: []byte(value)}, nil
+	case "ed25519":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		edKey, ok := priv.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("JWT key %q: not an Ed25519 private key", kid)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, sign: edKey, verify: edKey.Public()}, nil
+	case "ed25519-pub":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, verify: pub}, nil
+	}
+	return nil, fmt.Errorf("JWT key %q: unknown type %q (hs256, ed25519, ed25519-pub)", kid, typ)
+}
+
+// Sign signs claims with the current signing key.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+	return token.SignedString(k.signing.sign)
+}
+
+// Parse verifies a token against the key its kid names. Tokens without a
+// kid, with an unknown kid, or whose alg does not match the key are
+// rejected, as are tokens without an expiry.
+func (k *Keyring) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key := k.keys[kid]
+		if key == nil {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("kid %q does not accept alg %s", kid, t.Method.Alg())
+		}
+		return key.verify, nil
+	}, jwt.WithValidMethods(k.methods), jwt.WithExpirationRequired())
+}
+
+// Keys lists the configured keys, signing key first.
+func (k *Keyring) Keys() []KeyInfo {
+	list := []KeyInfo{}
+	for _, key := range k.keys {
+		list = append(list, KeyInfo{
+			ID:      key.id,
+			Alg:     key.method.Alg(),
+			Signing: key == k.signing,
+			CanSign: key.sign != nil,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Signing != list[j].Signing {
+			return list[i].Signing
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
@@ -0,0 +1,197 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeEdKeys writes a fresh Ed25519 key pair as PEM files and returns the
+// paths and the public key.
+func writeEdKeys(t *testing.T) (privFile, pubFile string, pub ed25519.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privFile = filepath.Join(dir, "ed.pem")
+	pubFile = filepath.Join(dir, "ed.pub.pem")
+	if err := os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return privFile, pubFile, pub
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+// rawToken signs claims with method and key under an arbitrary kid, the
+// way an attacker would.
+func rawToken(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNewKeyring(t *testing.T) {
+	privFile, pubFile, _ := writeEdKeys(t)
+
+	tests := []struct {
+		name       string
+		spec, kid  string
+		wantErr    string
+		wantSigner string
+	}{
+		{"secret only", "", "", "", "default"},
+		{"first entry signs", "a=hs256:" + testSecret + ",b=ed25519:" + privFile, "", "", "a"},
+		{"signing kid", "a=hs256:" + testSecret + ",b=ed25519:" + privFile, "b", "", "b"},
+		{"type is case-insensitive", " a = HS256:" + testSecret, "", "", "a"},
+		{"missing type", "a=" + testSecret, "", "invalid entry", ""},
+		{"missing kid", "=hs256:" + testSecret, "", "invalid entry", ""},
+		{"empty value", "a=hs256:", "", "invalid entry", ""},
+		{"duplicate kid", "a=hs256:" + testSecret + ",a=hs256:" + testSecret, "", "duplicate kid", ""},
+		{"unknown type", "a=rs256:" + testSecret, "", "unknown type", ""},
+		{"missing key file", "a=ed25519:" + filepath.Join(t.TempDir(), "none.pem"), "", "JWT key \"a\"", ""},
+		{"public key as private", "a=ed25519:" + pubFile, "", "JWT key \"a\"", ""},
+		{"only separators", ",,", "", "no keys", ""},
+		{"unknown signing kid", "a=hs256:" + testSecret, "b", "unknown kid", ""},
+		{"verify-only signing key", "a=ed25519-pub:" + pubFile, "", "can only verify", ""},
+	}
+	for _, tt := range tests {
+		k, err := NewKeyring(testSecret, tt.spec, tt.kid)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if k.signing.id != tt.wantSigner {
+			t.Errorf("%s: signing kid %q, want %q", tt.name, k.signing.id, tt.wantSigner)
+		}
+	}
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	privFile, pubFile, _ := writeEdKeys(t)
+	for _, spec := range []string{
+		"hs=hs256:" + testSecret,
+		"ed=ed25519:" + privFile,
+	} {
+		k, err := NewKeyring("", spec, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := k.Sign(validClaims())
+		if err != nil {
+			t.Fatalf("%s: sign: %v", spec, err)
+		}
+		if _, err := k.Parse(token); err != nil {
+			t.Errorf("%s: own token rejected: %v", spec, err)
+		}
+	}
+
+	// A verify-only copy of the public key accepts tokens signed with the
+	// private key, which is how rotation to a new key pair works.
+	signer, _ := NewKeyring("", "ed=ed25519:"+privFile, "")
+	token, _ := signer.Sign(validClaims())
+	verifier, err := NewKeyring("", "hs=hs256:"+testSecret+",ed=ed25519-pub:"+pubFile, "hs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Parse(token); err != nil {
+		t.Errorf("token rejected by the public key: %v", err)
+	}
+}
+
+func TestKeyringRejects(t *testing.T) {
+	privFile, _, pub := writeEdKeys(t)
+	k, err := NewKeyring("", "hs=hs256:"+testSecret+",ed=ed25519:"+privFile, "hs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := k.keys["ed"].sign
+	noExp := jwt.MapClaims{"user_id": 1}
+	expired := jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(-time.Minute).Unix()}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no kid", rawToken(t, jwt.SigningMethodHS256, "", validClaims(), []byte(testSecret))},
+		{"unknown kid", rawToken(t, jwt.SigningMethodHS256, "old", validClaims(), []byte(testSecret))},
+		// Algorithm confusion: an HMAC token keyed with the (public)
+		// Ed25519 key, presented under the Ed25519 kid.
+		{"HS256 under Ed25519 kid", rawToken(t, jwt.SigningMethodHS256, "ed", validClaims(), []byte(pub))},
+		{"HS256 secret under Ed25519 kid", rawToken(t, jwt.SigningMethodHS256, "ed", validClaims(), []byte(testSecret))},
+		// A genuine Ed25519 signature is not accepted under the HMAC kid.
+		{"EdDSA under HS256 kid", rawToken(t, jwt.SigningMethodEdDSA, "hs", validClaims(), edKey)},
+		{"HS512 under HS256 kid", rawToken(t, jwt.SigningMethodHS512, "hs", validClaims(), []byte(testSecret))},
+		{"alg none", rawToken(t, jwt.SigningMethodNone, "hs", validClaims(), jwt.UnsafeAllowNoneSignatureType)},
+		{"wrong secret", rawToken(t, jwt.SigningMethodHS256, "hs", validClaims(), []byte(testSecret+"x"))},
+		{"missing exp", rawToken(t, jwt.SigningMethodHS256, "hs", noExp, []byte(testSecret))},
+		{"expired", rawToken(t, jwt.SigningMethodHS256, "hs", expired, []byte(testSecret))},
+	}
+	for _, tt := range tests {
+		if _, err := k.Parse(tt.token); err == nil {
+			t.Errorf("%s: token accepted", tt.name)
+		}
+	}
+
+	// The same tokens signed properly are accepted, so the rejections above
+	// are down to the attack and not the setup.
+	if _, err := k.Parse(rawToken(t, jwt.SigningMethodHS256, "hs", validClaims(), []byte(testSecret))); err != nil {
+		t.Errorf("valid HS256 token rejected: %v", err)
+	}
+	if _, err := k.Parse(rawToken(t, jwt.SigningMethodEdDSA, "ed", validClaims(), edKey)); err != nil {
+		t.Errorf("valid EdDSA token rejected: %v", err)
+	}
+}
@@ -17,17 +17,29 @@ package middleware
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"suv/internal/metrics"
 	"suv/internal/model"
+	"suv/internal/perm"
 )
 
-func JWTAuth(secret string) gin.HandlerFunc {
+// JWTAuth accepts a token only while its session row (keyed by the jti
+// claim) is unrevoked and unexpired, so logging out, deleting a user or
+// resetting a password takes effect immediately. Role, department and
+// building are read from the users table, not the token, and the role's
+// permissions come from roles. Linked classes are only loaded for roles
+// that need them.
+func JWTAuth(keys *Keyring, db *sql.DB, roles *perm.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenStr := ""
 
@@ -45,38 +57,100 @@ func JWTAuth(secret string) gin.HandlerFunc {
 		}
 
 		if tokenStr == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
-			c.Abort()
+			unauthorized(c, "未登录")
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		})
-
+		token, err := keys.Parse(tokenStr)
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已过期"})
-			c.Abort()
+			unauthorized(c, "登录已过期")
 			return
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效凭证"})
-			c.Abort()
+			unauthorized(c, "无效凭证")
+			return
+		}
+		userID, _ := claims["user_id"].(float64)
+		username, _ := claims["username"].(string)
+		jti, _ := claims["jti"].(string)
+		if jti == "" || userID == 0 {
+			// Tokens issued before sessions existed.
+			unauthorized(c, "登录已过期")
 			return
 		}
 
+		var role, department, building string
+		err = db.QueryRow(`SELECT u.role, u.department, u.building FROM sessions s JOIN users u ON u.id = s.user_id
+			WHERE s.jti = ? AND s.user_id = ? AND s.revoked_at IS NULL AND s.expires_at > NOW()`,
+			jti, uint(userID)).Scan(&role, &department, &building)
+		if err != nil {
+			unauthorized(c, "登录已失效，请重新登录")
+			return
+		}
+		// last_seen_at only needs minute precision; skip the write otherwise.
+		db.Exec("UPDATE sessions SET last_seen_at = NOW() WHERE jti = ? AND last_seen_at < NOW() - INTERVAL 1 MINUTE", jti)
+
+		perms := roles.Permissions(role)
+		var classes []string
+		if perms.NeedsClasses() {
+			classes, err = userClasses(db, uint(userID))
+			if err != nil {
+				log.Printf("Load classes of user %d error: %v", uint(userID), err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "系统错误"})
+				c.Abort()
+				return
+			}
+		}
+
 		c.Set("user", model.Claims{
-			UserID:   uint(claims["user_id"].(float64)),
-			Username: claims["username"].(string),
-			Role:     claims["role"].(string),
+			UserID:     uint(userID),
+			Username:   username,
+			Role:       role,
+			Department: department,
+			Building:   building,
+			Classes:    classes,
+			SessionID:  jti,
+			Perms:      perms,
 		})
 		c.Next()
 	}
 }
 
-func AdminOnly() gin.HandlerFunc {
+func userClasses(db *sql.DB, userID uint) ([]string, error) {
+	rows, err := db.Query("SELECT class_name FROM user_classes WHERE user_id = ? ORDER BY class_name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var classes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		classes = append(classes, name)
+	}
+	return classes, rows.Err()
+}
+
+// unauthorized rejects a request without a valid access token. API calls
+// get a 401 (the browser client refreshes and retries); page loads are sent
+// to the login page, which refreshes and comes back.
+func unauthorized(c *gin.Context, msg string) {
+	if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+	} else {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+	}
+	c.Abort()
+}
+
+// Require lets the request through if the user's role grants any of the
+// permissions, at any level. Handlers narrow scoped grants (:own-dept,
+// :own-building, :own-class, :own) down to the records they cover.
+func Require(permissions ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
@@ -85,23 +159,51 @@ func AdminOnly() gin.HandlerFunc {
 			return
 		}
 		claims := user.(model.Claims)
-		if claims.Role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
-			c.Abort()
-			return
+		for _, p := range permissions {
+			if claims.Perms.Has(p) {
+				c.Next()
+				return
+			}
 		}
-		c.Next()
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		c.Abort()
 	}
 }
 
-func GenerateToken(secret string, user model.User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// AdminOnly admits roles holding every permission ("*"). Prefer Require
+// with the specific permission.
+func AdminOnly() gin.HandlerFunc {
+	return Require(perm.All)
+}
+
+// GenerateToken signs a token for the session jti, which the caller must
+// already have stored. It carries no role: JWTAuth reads the role and
+// scope from the database on every request.
+func GenerateToken(keys *Keyring, user model.User, jti string, expires time.Time) (string, error) {
+	return keys.Sign(jwt.MapClaims{
 		"user_id":  user.ID,
 		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"jti":      jti,
+		"exp":      expires.Unix(),
 	})
-	return token.SignedString([]byte(secret))
+}
+
+// Metrics records request counts and latency per route. The route is the
+// registered pattern (/api/violations/:id), not the raw path, so ids do not
+// blow up the number of series.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		metrics.HTTPRequests.Inc(route, method, strconv.Itoa(c.Writer.Status()))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), route, method)
+	}
 }
 
 // CSRF middleware
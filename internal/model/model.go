@@ -15,14 +15,22 @@
 
 package model
 
-import "time"
+import (
+	"time"
+
+	"suv/internal/perm"
+)
 
 type User struct {
 	ID           uint      `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
 	DisplayName  string    `json:"display_name"`
-	Role         string    `json:"role"` // "admin" or "staff"
+	Role         string    `json:"role"` // name of a row in roles
+	Department   string    `json:"department"`
+	Building     string    `json:"building"` // dorm building a supervisor is responsible for
+	Classes      []string  `json:"classes"`  // classes a teacher account is linked to
+	MustChange   bool      `json:"must_change_password"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
@@ -34,6 +42,7 @@ type Violation struct {
 	Period      string    `json:"period"`
 	Reason      string    `json:"reason"`
 	Department  string    `json:"department"`
+	Category    string    `json:"category"`
 	Inspector   string    `json:"inspector"`
 	PhotoPath   string    `json:"photo_path"`
 	CreatedBy   uint      `json:"created_by"`
@@ -41,23 +50,278 @@ type Violation struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// PublicViolation is what the public screens get: no photo, inspector or
+// creator, and name and reason masked according to the display's level.
+type PublicViolation struct {
+	ID          uint      `json:"id"`
+	Dorm        string    `json:"dorm"`
+	StudentName string    `json:"student_name"`
+	ClassName   string    `json:"class_name"`
+	Period      string    `json:"period"`
+	Reason      string    `json:"reason"`
+	Department  string    `json:"department"`
+	Category    string    `json:"category"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"` // length checked by the password policy
+}
+
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
 type ViolationRequest struct {
-	Dorm        string `form:"dorm" binding:"required,max=20"`
-	StudentName string `form:"student_name" binding:"required,max=50"`
-	ClassName   string `form:"class_name" binding:"required,max=50"`
-	Period      string `form:"period" binding:"required,max=20"`
-	Reason      string `form:"reason" binding:"required,max=2000"`
-	Department  string `form:"department" binding:"required,max=30"`
-	Inspector   string `form:"inspector" binding:"required,max=100"`
+	Dorm        string `form:"dorm" json:"dorm" binding:"required,max=20"`
+	StudentName string `form:"student_name" json:"student_name" binding:"required,max=50"`
+	ClassName   string `form:"class_name" json:"class_name" binding:"required,max=50"`
+	Period      string `form:"period" json:"period" binding:"required,max=20"`
+	Reason      string `form:"reason" json:"reason" binding:"required,max=2000"`
+	Department  string `form:"department" json:"department" binding:"required,max=30"`
+	Category    string `form:"category" json:"category" binding:"max=30"`
+	Inspector   string `form:"inspector" json:"inspector" binding:"required,max=100"`
+}
+
+type Category struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Points    int    `json:"points"` // deduction per violation
+	SortOrder int    `json:"sort_order"`
+}
+
+type CategoryRequest struct {
+	Name      string `json:"name" binding:"required,max=30"`
+	Points    int    `json:"points" binding:"min=0,max=100"`
+	SortOrder int    `json:"sort_order"`
+}
+
+type Class struct {
+	ID           uint   `json:"id"`
+	Name         string `json:"name"`
+	Grade        string `json:"grade"`
+	StudentCount int    `json:"student_count"`
+}
+
+type ClassRequest struct {
+	Name         string `json:"name" binding:"required,max=50"`
+	Grade        string `json:"grade" binding:"required,max=20"`
+	StudentCount int    `json:"student_count" binding:"required,min=1,max=500"`
+}
+
+type ClassRank struct {
+	Rank           int     `json:"rank"`
+	ClassName      string  `json:"class_name"`
+	Grade          string  `json:"grade"`
+	StudentCount   int     `json:"student_count"`
+	ViolationCount int     `json:"violation_count"`
+	Points         int     `json:"points"`
+	Rate           float64 `json:"rate"` // points per 100 students
+}
+
+type RedFlagAward struct {
+	ID           uint      `json:"id"`
+	WeekStart    string    `json:"week_start"`
+	Grade        string    `json:"grade"`
+	ClassName    string    `json:"class_name"`
+	Points       int       `json:"points"`
+	Rate         float64   `json:"rate"`
+	LockedBy     uint      `json:"locked_by"`
+	LockedByName string    `json:"locked_by_name"` // joined field
+	LockedAt     time.Time `json:"locked_at"`
+}
+
+// Display is a registered public screen. It authenticates with its own
+// read-only token and may be limited to one building or grade.
+type Display struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	MaskLevel   string     `json:"mask_level"` // "none", "partial" or "strict"
+	Building    string     `json:"building"`   // empty = all
+	Grade       string     `json:"grade"`      // empty = all
+	TokenPrefix string     `json:"token_prefix"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+	LastSeenIP  string     `json:"last_seen_ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type DisplayRequest struct {
+	Name      string `json:"name" binding:"required,max=50"`
+	MaskLevel string `json:"mask_level" binding:"required,oneof=none partial strict"`
+	Building  string `json:"building" binding:"max=20"`
+	Grade     string `json:"grade" binding:"max=20"`
+}
+
+// NoticeApproval releases a past day's or week's notice to the public feed.
+type NoticeApproval struct {
+	ID             uint      `json:"id"`
+	Kind           string    `json:"kind"`       // "day" or "week"
+	StartDate      string    `json:"start_date"` // the day, or the Monday of the week
+	ApprovedBy     uint      `json:"approved_by"`
+	ApprovedByName string    `json:"approved_by_name"` // joined field
+	ApprovedAt     time.Time `json:"approved_at"`
+}
+
+type NoticeApprovalRequest struct {
+	Kind string `json:"kind" binding:"required,oneof=day week"`
+	Date string `json:"date" binding:"required"`
+}
+
+type Announcement struct {
+	ID          uint       `json:"id"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	Audience    string     `json:"audience"` // "public", "staff" or "all"
+	Pinned      bool       `json:"pinned"`
+	PublishAt   time.Time  `json:"publish_at"`
+	ExpireAt    *time.Time `json:"expire_at"`
+	CreatedBy   uint       `json:"created_by"`
+	CreatorName string     `json:"creator_name"` // joined field
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type AnnouncementRequest struct {
+	Title     string `json:"title" binding:"required,max=100"`
+	Body      string `json:"body" binding:"required,max=5000"`
+	Audience  string `json:"audience" binding:"required,oneof=public staff all"`
+	Pinned    bool   `json:"pinned"`
+	PublishAt string `json:"publish_at"` // empty = now
+	ExpireAt  string `json:"expire_at"`  // empty = never
+}
+
+type LoginThrottle struct {
+	ID            uint       `json:"id"`
+	Scope         string     `json:"scope"`   // "user" or "ip"
+	Subject       string     `json:"subject"` // username or IP address
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	Locked        bool       `json:"locked"`
+}
+
+// Role is a named set of permissions (see package perm).
+type Role struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Label       string    `json:"label"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"builtin"`
+	Users       int       `json:"users"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name" binding:"required,max=30"`
+	Label       string   `json:"label" binding:"max=50"`
+	Permissions []string `json:"permissions"`
+}
+
+// Session is one login. The token carries JTI; revoking the row logs that
+// token out even though it has not expired.
+type Session struct {
+	ID            uint       `json:"id"`
+	UserID        uint       `json:"user_id"`
+	Username      string     `json:"username,omitempty"`
+	IP            string     `json:"ip"`
+	UserAgent     string     `json:"user_agent"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	Current       bool       `json:"current"`
+}
+
+// AuditEntry records who did what. Before and After are JSON snapshots of
+// the target; either may be empty.
+type AuditEntry struct {
+	ID         uint64    `json:"id"`
+	ActorID    uint      `json:"actor_id"` // 0 for anonymous (failed logins, screens)
+	ActorName  string    `json:"actor_name"`
+	Action     string    `json:"action"` // e.g. "violation.delete", "login.failure"
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Appeal is a request to correct or withdraw a record, e.g. from a class
+// teacher. Accepting it does not change the record by itself.
+type Appeal struct {
+	ID          uint       `json:"id"`
+	ViolationID uint       `json:"violation_id"`
+	StudentName string     `json:"student_name"` // joined from the record
+	ClassName   string     `json:"class_name"`   // joined from the record
+	Violation   string     `json:"violation"`    // the record's reason
+	UserID      uint       `json:"user_id"`
+	UserName    string     `json:"user_name"` // joined field
+	Reason      string     `json:"reason"`
+	Status      string     `json:"status"` // "pending", "accepted" or "rejected"
+	Reply       string     `json:"reply"`
+	HandlerName string     `json:"handler_name"` // joined field
+	HandledAt   *time.Time `json:"handled_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type AppealRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+type AppealDecision struct {
+	Status string `json:"status" binding:"required,oneof=accepted rejected"`
+	Reply  string `json:"reply" binding:"max=500"`
+}
+
+type ReportJob struct {
+	ID        uint       `json:"id"`
+	Name      string     `json:"name"`
+	Cron      string     `json:"cron"`
+	Format    string     `json:"format"`    // "csv", "xlsx" or "pdf"
+	Range     string     `json:"range"`     // rolling range, e.g. "yesterday", "lastweek"
+	Building  string     `json:"building"`  // only rooms in this building; empty = all
+	Period    string     `json:"period"`    // only this period, e.g. "晚休"; empty = all
+	Retention int        `json:"retention"` // files kept on disk, 0 = keep all
+	Enabled   bool       `json:"enabled"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ReportJobRun struct {
+	ID         uint       `json:"id"`
+	JobID      uint       `json:"job_id"`
+	JobName    string     `json:"job_name"` // joined field
+	Status     string     `json:"status"`   // "running", "success" or "failed"
+	FilePath   string     `json:"file_path"`
+	FileSize   int64      `json:"file_size"`
+	Error      string     `json:"error"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+type ReportJobRequest struct {
+	Name      string `json:"name" binding:"required,max=100"`
+	Cron      string `json:"cron" binding:"required,max=100"`
+	Format    string `json:"format" binding:"required,oneof=csv xlsx pdf"`
+	Range     string `json:"range" binding:"required"`
+	Building  string `json:"building" binding:"max=20"`
+	Period    string `json:"period" binding:"max=20"`
+	Retention int    `json:"retention" binding:"min=0,max=1000"`
+	Enabled   *bool  `json:"enabled"`
 }
 
 type Claims struct {
-	UserID   uint   `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID     uint     `json:"user_id"`
+	Username   string   `json:"username"`
+	Role       string   `json:"role"`
+	Department string   `json:"department"`
+	Building   string   `json:"building"`
+	Classes    []string `json:"classes,omitempty"` // only loaded for :own-class roles
+	SessionID  string   `json:"jti"`
+	Perms      perm.Set `json:"-"` // resolved from Role on every request
 }
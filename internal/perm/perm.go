@@ -0,0 +1,214 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+// Package perm defines the permissions roles are made of.
+//
+// A permission on records can be granted outright ("violation:delete") or
+// limited to the user's department ("violation:delete:own-dept"), to the
+// dorm building of the user ("violation:create:own-building"), to the
+// classes linked to the user ("violation:read:own-class") or to records
+// the user entered ("violation:delete:own"). "*" grants everything.
+package perm
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	ViolationCreate = "violation:create"
+	ViolationRead   = "violation:read"
+	ViolationUpdate = "violation:update"
+	ViolationDelete = "violation:delete"
+	Export          = "export"
+	Stats           = "stats"
+	UserManage      = "user:manage"
+	RoleManage      = "role:manage"
+	AuditRead       = "audit:read"
+	AppealCreate    = "appeal:create"
+	AppealHandle    = "appeal:handle"
+	Settings        = "settings:manage" // categories, classes, rankings, displays, announcements, reports
+
+	All = "*"
+
+	suffixDept     = ":own-dept"
+	suffixBuilding = ":own-building"
+	suffixClass    = ":own-class"
+	suffixOwn      = ":own"
+)
+
+// Level is how far a permission reaches.
+type Level int
+
+const (
+	None     Level = iota
+	Own            // records the user entered
+	Class          // records of the user's classes
+	Building       // records of rooms in the user's building
+	Dept           // records of the user's department
+	Any            // every record
+)
+
+// Def describes a permission for the role editor. Scoped permissions also
+// exist with the :own-dept, :own-building, :own-class and :own suffixes.
+type Def struct {
+	Name   string `json:"name"`
+	Label  string `json:"label"`
+	Scoped bool   `json:"scoped"`
+}
+
+var Catalog = []Def{
+	{ViolationCreate, "录入违纪", true},
+	{ViolationRead, "查看违纪记录", true},
+	{ViolationUpdate, "修改违纪记录", true},
+	{ViolationDelete, "删除违纪记录", true},
+	{Export, "导出", true},
+	{Stats, "统计分析", false},
+	{UserManage, "用户管理", false},
+	{RoleManage, "角色权限管理", false},
+	{AuditRead, "操作日志", false},
+	{AppealCreate, "对违纪记录提出申诉", false},
+	{AppealHandle, "处理申诉（限可修改的记录）", false},
+	{Settings, "系统设置（类别、班级、排名、屏幕、公告、定时报表）", false},
+}
+
+// Builtin roles are created on first start. Only admin cannot be edited.
+var Builtin = []struct {
+	Name, Label string
+	Permissions []string
+}{
+	{"admin", "管理员", []string{All}},
+	{"staff", "干事", []string{ViolationCreate, ViolationRead, ViolationUpdate + suffixOwn, Stats}},
+	{"dept_head", "部长", []string{
+		ViolationCreate, ViolationRead + suffixDept, ViolationUpdate + suffixDept,
+		ViolationDelete + suffixDept, Export + suffixDept, Stats, AppealHandle,
+	}},
+	{"teacher", "班主任", []string{ViolationRead + suffixClass, Export + suffixClass, Stats, AppealCreate}},
+	{"dorm_supervisor", "宿管", []string{
+		ViolationCreate + suffixBuilding, ViolationRead + suffixBuilding, ViolationUpdate + suffixOwn,
+		Export + suffixBuilding, Stats,
+	}},
+}
+
+// Valid reports whether p is a known permission.
+func Valid(p string) bool {
+	if p == All {
+		return true
+	}
+	for _, d := range Catalog {
+		if p == d.Name {
+			return true
+		}
+		if d.Scoped && strings.HasPrefix(p, d.Name+":") {
+			switch p[len(d.Name):] {
+			case suffixDept, suffixBuilding, suffixClass, suffixOwn:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Set is the permissions of one role.
+type Set map[string]bool
+
+// Parse reads a comma-separated permission list. Unknown entries are
+// dropped.
+func Parse(s string) Set {
+	set := Set{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); Valid(p) {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+func (s Set) String() string {
+	return strings.Join(s.List(), ",")
+}
+
+func (s Set) List() []string {
+	list := make([]string, 0, len(s))
+	for p := range s {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Level returns the widest grant of action.
+func (s Set) Level(action string) Level {
+	switch {
+	case s[All] || s[action]:
+		return Any
+	case s[action+suffixDept]:
+		return Dept
+	case s[action+suffixBuilding]:
+		return Building
+	case s[action+suffixClass]:
+		return Class
+	case s[action+suffixOwn]:
+		return Own
+	}
+	return None
+}
+
+// NeedsClasses reports whether any permission is limited to the user's
+// classes, i.e. whether the class list has to be loaded.
+func (s Set) NeedsClasses() bool {
+	for p := range s {
+		if strings.HasSuffix(p, suffixClass) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether s grants everything in other, so that a holder
+// of s may hand other out. A scoped grant is covered by the same scope or
+// by the unscoped permission; "*" only by "*".
+func (s Set) Covers(other Set) bool {
+	if s[All] {
+		return true
+	}
+	for p := range other {
+		if p == All {
+			return false
+		}
+		action, level := split(p)
+		if have := s.Level(action); have != Any && have != level {
+			return false
+		}
+	}
+	return true
+}
+
+// split separates a permission into its action and level.
+func split(p string) (string, Level) {
+	for suffix, level := range map[string]Level{
+		suffixDept: Dept, suffixBuilding: Building, suffixClass: Class, suffixOwn: Own,
+	} {
+		if action, ok := strings.CutSuffix(p, suffix); ok {
+			return action, level
+		}
+	}
+	return p, Any
+}
+
+// Has reports whether action is granted at any level.
+func (s Set) Has(action string) bool {
+	return s.Level(action) != None
+}
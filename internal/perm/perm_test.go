@@ -0,0 +1,146 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package perm
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		p    string
+		want bool
+	}{
+		{All, true},
+		{ViolationRead, true},
+		{ViolationRead + ":own-dept", true},
+		{ViolationCreate + ":own-building", true},
+		{Export + ":own-class", true},
+		{ViolationDelete + ":own", true},
+		{Stats + ":own-dept", false}, // not scoped
+		{ViolationRead + ":own-room", false},
+		{ViolationRead + ":", false},
+		{"violation", false},
+		{"violation:*", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.p); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	got := Parse(" violation:read , bogus,,export:own-class,stats:own").String()
+	if want := "export:own-class,violation:read"; got != want {
+		t.Errorf("Parse = %q, want %q", got, want)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		perms  string
+		action string
+		want   Level
+	}{
+		{"*", ViolationDelete, Any},
+		{"*", Settings, Any},
+		{"violation:read", ViolationRead, Any},
+		{"violation:read:own-dept", ViolationRead, Dept},
+		{"violation:read:own-building", ViolationRead, Building},
+		{"violation:read:own-class", ViolationRead, Class},
+		{"violation:read:own", ViolationRead, Own},
+		// The widest grant wins.
+		{"violation:read:own,violation:read:own-dept", ViolationRead, Dept},
+		{"violation:read:own-class,violation:read", ViolationRead, Any},
+		// Other actions are not affected.
+		{"violation:read", ViolationUpdate, None},
+		{"violation:update:own", ViolationDelete, None},
+		{"", ViolationRead, None},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.perms).Level(tt.action); got != tt.want {
+			t.Errorf("Parse(%q).Level(%q) = %v, want %v", tt.perms, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		p      string
+		action string
+		level  Level
+	}{
+		{"violation:read", "violation:read", Any},
+		{"violation:read:own-dept", "violation:read", Dept},
+		{"violation:read:own-building", "violation:read", Building},
+		{"violation:read:own-class", "violation:read", Class},
+		{"violation:read:own", "violation:read", Own},
+		{"stats", "stats", Any},
+	}
+	for _, tt := range tests {
+		if action, level := split(tt.p); action != tt.action || level != tt.level {
+			t.Errorf("split(%q) = %q, %v, want %q, %v", tt.p, action, level, tt.action, tt.level)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		have, grant string
+		want        bool
+	}{
+		// "*" covers everything, and only "*" covers "*".
+		{"*", "*", true},
+		{"*", "violation:delete,user:manage", true},
+		{"violation:read,violation:update,violation:delete,user:manage", "*", false},
+
+		// Unscoped covers unscoped and every scope of the same action.
+		{"violation:read", "violation:read", true},
+		{"violation:read", "violation:read:own-dept", true},
+		{"violation:read", "violation:read:own-building", true},
+		{"violation:read", "violation:read:own-class", true},
+		{"violation:read", "violation:read:own", true},
+
+		// A scoped grant covers the same scope only.
+		{"violation:read:own-dept", "violation:read:own-dept", true},
+		{"violation:read:own-dept", "violation:read", false},
+		{"violation:read:own-dept", "violation:read:own-building", false},
+		{"violation:read:own-dept", "violation:read:own", false},
+		{"violation:read:own", "violation:read:own-dept", false},
+
+		// Every permission has to be covered, action by action.
+		{"violation:read", "violation:update", false},
+		{"violation:read,stats", "violation:read:own-class,stats", true},
+		{"violation:read,stats", "violation:read,stats,export", false},
+		{"stats", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.have).Covers(Parse(tt.grant)); got != tt.want {
+			t.Errorf("Parse(%q).Covers(%q) = %v, want %v", tt.have, tt.grant, got, tt.want)
+		}
+	}
+}
+
+func TestBuiltinValid(t *testing.T) {
+	for _, r := range Builtin {
+		for _, p := range r.Permissions {
+			if !Valid(p) {
+				t.Errorf("builtin role %s: invalid permission %q", r.Name, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package perm
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// reloadEvery bounds how stale the cache can get when roles are edited by
+// another server process.
+const reloadEvery = time.Minute
+
+// Store caches the roles table. Every authenticated request looks up its
+// role here, so the table is read at most once a minute (or right after
+// Invalidate).
+type Store struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	roles  map[string]Set
+	loaded time.Time
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Permissions returns the permissions of role; unknown roles get none.
+func (s *Store) Permissions(role string) Set {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles == nil || time.Since(s.loaded) > reloadEvery {
+		if err := s.load(); err != nil {
+			log.Printf("Load roles error: %v", err)
+		}
+	}
+	if set, ok := s.roles[role]; ok {
+		return set
+	}
+	return Set{}
+}
+
+// Invalidate makes the next lookup reread the table.
+func (s *Store) Invalidate() {
+	s.mu.Lock()
+	s.loaded = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *Store) load() error {
+	rows, err := s.db.Query("SELECT name, permissions FROM roles")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	roles := map[string]Set{}
+	for rows.Next() {
+		var name, perms string
+		if err := rows.Scan(&name, &perms); err != nil {
+			return err
+		}
+		roles[name] = Parse(perms)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.roles, s.loaded = roles, time.Now()
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"suv/internal/model"
+)
+
+// Summary holds violation counts per class and category for a date range,
+// used to compare one class against the school average. Classes without
+// violations count towards the average once registered with AddClass.
+type Summary struct {
+	Total      int
+	ByCategory map[string]int
+	Classes    map[string]*ClassSummary
+}
+
+type ClassSummary struct {
+	Total      int
+	ByCategory map[string]int
+}
+
+func NewSummary() *Summary {
+	return &Summary{ByCategory: map[string]int{}, Classes: map[string]*ClassSummary{}}
+}
+
+// AddClass registers a class from the roster, with or without violations.
+func (s *Summary) AddClass(class string) *ClassSummary {
+	cs := s.Classes[class]
+	if cs == nil {
+		cs = &ClassSummary{ByCategory: map[string]int{}}
+		s.Classes[class] = cs
+	}
+	return cs
+}
+
+func (s *Summary) Add(class, category string, count int) {
+	cs := s.AddClass(class)
+	cs.Total += count
+	cs.ByCategory[category] += count
+	s.Total += count
+	s.ByCategory[category] += count
+}
+
+// Average is the mean number of violations per class over the roster plus
+// any class that appears in the range. Without a roster only classes with
+// violations are counted.
+func (s *Summary) Average() float64 {
+	if len(s.Classes) == 0 {
+		return 0
+	}
+	return float64(s.Total) / float64(len(s.Classes))
+}
+
+func (s *Summary) CategoryAverage(category string) float64 {
+	if len(s.Classes) == 0 {
+		return 0
+	}
+	return float64(s.ByCategory[category]) / float64(len(s.Classes))
+}
+
+func (s *Summary) Categories() []string {
+	out := make([]string, 0, len(s.ByCategory))
+	for k := range s.ByCategory {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ClassReport writes one class's report as CSV: a summary block comparing
+// the class with the school average, then the detail rows as they are
+// streamed in.
+type ClassReport struct {
+	w *csv.Writer
+}
+
+func NewClassReport(out io.Writer, s *Summary, class string, start, end time.Time) (*ClassReport, error) {
+	if _, err := io.WriteString(out, "\xEF\xBB\xBF"); err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(out)
+
+	cs := s.Classes[class]
+	if cs == nil {
+		cs = &ClassSummary{ByCategory: map[string]int{}}
+	}
+	avg := s.Average()
+
+	last := end.AddDate(0, 0, -1)
+	w.Write([]string{"班级", class})
+	w.Write([]string{"日期范围", start.Format("2006-01-02") + " 至 " + last.Format("2006-01-02")})
+	w.Write([]string{"本班违纪", strconv.Itoa(cs.Total)})
+	w.Write([]string{"全校班均", formatFloat(avg)})
+	w.Write([]string{"对比", compare(float64(cs.Total), avg)})
+	w.Write(nil)
+
+	w.Write([]string{"类别", "本班", "全校班均"})
+	for _, cat := range s.Categories() {
+		w.Write([]string{cat, strconv.Itoa(cs.ByCategory[cat]), formatFloat(s.CategoryAverage(cat))})
+	}
+	w.Write(nil)
+
+	w.Write([]string{"ID", "宿舍号", "姓名", "时间段", "类别", "违纪原因", "部门", "执勤人", "记录时间"})
+	return &ClassReport{w: w}, w.Error()
+}
+
+func (r *ClassReport) Row(v model.Violation) error {
+	return r.w.Write([]string{
+		strconv.FormatUint(uint64(v.ID), 10), v.Dorm, v.StudentName, v.Period, v.Category, v.Reason,
+		v.Department, v.Inspector, v.CreatedAt.Format("2006-01-02 15:04:05"),
+	})
+}
+
+func (r *ClassReport) Close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// SafeFilename strips characters that are not allowed in file names on
+// Windows, where most of these packs end up being opened.
+func SafeFilename(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`\/:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" {
+		return "未命名"
+	}
+	return s
+}
+
+func compare(v, avg float64) string {
+	diff := v - avg
+	switch {
+	case avg == 0:
+		return "—"
+	case diff > 0:
+		return fmt.Sprintf("高于全校平均 %s 条（+%.0f%%）", formatFloat(diff), diff/avg*100)
+	case diff < 0:
+		return fmt.Sprintf("低于全校平均 %s 条（%.0f%%）", formatFloat(-diff), diff/avg*100)
+	}
+	return "与全校平均持平"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 1, 64)
+}
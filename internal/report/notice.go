@@ -0,0 +1,182 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"suv/internal/model"
+)
+
+// Notice is the printable 通报 posted on the notice board.
+type Notice struct {
+	School     string
+	Start, End time.Time // [Start, End)
+	Violations []model.Violation
+}
+
+const (
+	marginX      = 40.0
+	marginTop    = 50.0
+	marginBottom = 60.0
+	bodySize     = 9.0
+	lineHeight   = 13.0
+)
+
+type column struct {
+	title string
+	width float64
+}
+
+var noticeColumns = []column{
+	{"序号", 30}, {"日期", 62}, {"宿舍", 50}, {"姓名", 55},
+	{"时间段", 50}, {"违纪原因", 198}, {"部门", 70},
+}
+
+// Title returns e.g. "违纪通报（2025-03-03 至 2025-03-09）".
+func (n Notice) Title() string {
+	last := n.End.AddDate(0, 0, -1)
+	if last.Equal(n.Start) {
+		return fmt.Sprintf("违纪通报（%s）", n.Start.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("违纪通报（%s 至 %s）", n.Start.Format("2006-01-02"), last.Format("2006-01-02"))
+}
+
+// WriteNoticePDF lays out the violations grouped by class with per-class
+// and overall totals, followed by a signature line for the department head.
+func WriteNoticePDF(w io.Writer, n Notice) error {
+	p := NewPDF()
+	p.AddPage()
+
+	y := marginTop
+	p.TextCenter(y, 18, n.School)
+	y += 26
+	p.TextCenter(y, 14, n.Title())
+	y += 24
+
+	byClass := map[string][]model.Violation{}
+	for _, v := range n.Violations {
+		byClass[v.ClassName] = append(byClass[v.ClassName], v)
+	}
+	classes := make([]string, 0, len(byClass))
+	for name := range byClass {
+		classes = append(classes, name)
+	}
+	sort.Strings(classes)
+
+	tableHeader := func() {
+		p.FillRect(marginX, y, PageWidth-2*marginX, lineHeight+4, 0.9)
+		x := marginX
+		for _, col := range noticeColumns {
+			p.Text(x+3, y+lineHeight-2, bodySize, col.title)
+			x += col.width
+		}
+		y += lineHeight + 4
+		p.Line(marginX, y, PageWidth-marginX, y)
+	}
+	ensure := func(h float64) {
+		if y+h > PageHeight-marginBottom {
+			p.AddPage()
+			y = marginTop
+			tableHeader()
+		}
+	}
+
+	if len(classes) == 0 {
+		p.Text(marginX, y+lineHeight, 11, "本期无违纪记录。")
+		y += 2 * lineHeight
+	} else {
+		tableHeader()
+	}
+
+	seq := 0
+	for _, class := range classes {
+		list := byClass[class]
+		ensure(2 * lineHeight)
+		y += lineHeight + 2
+		p.Text(marginX, y, 10.5, fmt.Sprintf("%s　共 %d 条", class, len(list)))
+		y += 4
+		p.Line(marginX, y, PageWidth-marginX, y)
+
+		for _, v := range list {
+			seq++
+			reasonCol := noticeColumns[5]
+			reason := WrapText(v.Reason, bodySize, reasonCol.width-6)
+			rowH := float64(len(reason))*lineHeight + 4
+			ensure(rowH)
+
+			cells := []string{
+				fmt.Sprint(seq), v.CreatedAt.Format("01-02 15:04"), v.Dorm, v.StudentName,
+				v.Period, "", v.Department,
+			}
+			x := marginX
+			for i, col := range noticeColumns {
+				if i == 5 {
+					for j, line := range reason {
+						p.Text(x+3, y+lineHeight*float64(j+1)-2, bodySize, line)
+					}
+				} else {
+					p.Text(x+3, y+lineHeight-2, bodySize, TruncateText(cells[i], bodySize, col.width-6))
+				}
+				x += col.width
+			}
+			y += rowH
+			p.Line(marginX, y, PageWidth-marginX, y)
+		}
+	}
+
+	// Totals
+	byDept := map[string]int{}
+	for _, v := range n.Violations {
+		byDept[v.Department]++
+	}
+	depts := make([]string, 0, len(byDept))
+	for d := range byDept {
+		depts = append(depts, d)
+	}
+	sort.Strings(depts)
+	parts := make([]string, len(depts))
+	for i, d := range depts {
+		parts[i] = fmt.Sprintf("%s %d 条", d, byDept[d])
+	}
+
+	ensure(6 * lineHeight)
+	y += 2 * lineHeight
+	p.Text(marginX, y, 11, fmt.Sprintf("合计：%d 条违纪，涉及 %d 个班级。", len(n.Violations), len(classes)))
+	if len(parts) > 0 {
+		for _, line := range WrapText("按部门："+strings.Join(parts, "，"), 10, PageWidth-2*marginX) {
+			y += lineHeight + 2
+			p.Text(marginX, y, 10, line)
+		}
+	}
+
+	y += 3 * lineHeight
+	sig := "部门负责人（签字）：______________　　日期：______年____月____日"
+	p.Text(PageWidth-marginX-TextWidth(sig, 10.5), y, 10.5, sig)
+
+	total := p.PageCount()
+	for i := 0; i < total; i++ {
+		p.SetPage(i)
+		p.TextCenter(PageHeight-30, 8, fmt.Sprintf("第 %d 页 / 共 %d 页", i+1, total))
+	}
+
+	_, err := p.WriteTo(w)
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// A4 in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// PDF is a minimal single-font PDF writer. Text is set in STSong-Light, one
+// of the standard Adobe CJK fonts every PDF reader ships with, so nothing
+// has to be embedded and the output stays small.
+//
+// Coordinates passed to the drawing methods are measured from the top-left
+// corner of the page; y is the text baseline.
+type PDF struct {
+	pages []*bytes.Buffer
+	cur   int
+}
+
+func NewPDF() *PDF {
+	return &PDF{cur: -1}
+}
+
+func (p *PDF) AddPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.cur = len(p.pages) - 1
+}
+
+// SetPage switches drawing to an existing page, e.g. to add page numbers
+// once the total is known.
+func (p *PDF) SetPage(i int) {
+	if i >= 0 && i < len(p.pages) {
+		p.cur = i
+	}
+}
+
+func (p *PDF) PageCount() int {
+	return len(p.pages)
+}
+
+func (p *PDF) Text(x, y, size float64, s string) {
+	if p.cur < 0 {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.pages[p.cur], "BT /F1 %.2f Tf %.2f %.2f Td <%s> Tj ET\n",
+		size, x, PageHeight-y, encodeText(s))
+}
+
+// TextCenter draws s horizontally centred on the page.
+func (p *PDF) TextCenter(y, size float64, s string) {
+	p.Text((PageWidth-TextWidth(s, size))/2, y, size, s)
+}
+
+func (p *PDF) Line(x1, y1, x2, y2 float64) {
+	if p.cur < 0 {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.pages[p.cur], "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// FillRect paints a grey box, used for table header shading.
+func (p *PDF) FillRect(x, y, w, h, gray float64) {
+	if p.cur < 0 {
+		p.AddPage()
+	}
+	fmt.Fprintf(p.pages[p.cur], "q %.2f g %.2f %.2f %.2f %.2f re f Q\n",
+		gray, x, PageHeight-y-h, w, h)
+}
+
+// TextWidth estimates the rendered width: ASCII glyphs in STSong-Light are
+// half width, everything else is a full em.
+func TextWidth(s string, size float64) float64 {
+	w := 0.0
+	for _, r := range s {
+		if r < 0x80 {
+			w += size / 2
+		} else {
+			w += size
+		}
+	}
+	return w
+}
+
+// WrapText breaks s into lines no wider than width. Existing newlines are
+// kept as line breaks.
+func WrapText(s string, size, width float64) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line := ""
+		lw := 0.0
+		for _, r := range para {
+			rw := TextWidth(string(r), size)
+			if lw+rw > width && line != "" {
+				lines = append(lines, line)
+				line, lw = "", 0
+			}
+			line += string(r)
+			lw += rw
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// TruncateText shortens s to fit width, marking the cut with an ellipsis.
+func TruncateText(s string, size, width float64) string {
+	if TextWidth(s, size) <= width {
+		return s
+	}
+	out := ""
+	w := TextWidth("…", size)
+	for _, r := range s {
+		rw := TextWidth(string(r), size)
+		if w+rw > width {
+			break
+		}
+		out += string(r)
+		w += rw
+	}
+	return out + "…"
+}
+
+// encodeText converts s to the hex UTF-16BE string expected by the
+// UniGB-UCS2-H CMap. Characters outside the BMP have no mapping there and
+// are replaced.
+func encodeText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 {
+			r = ' '
+		}
+		if r > 0xFFFF {
+			r = '?'
+		}
+		for _, u := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&b, "%04X", u)
+		}
+	}
+	return b.String()
+}
+
+func (p *PDF) WriteTo(w io.Writer) (int64, error) {
+	if len(p.pages) == 0 {
+		p.AddPage()
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	var offsets []int64
+
+	obj := func(body string) {
+		offsets = append(offsets, cw.n)
+		fmt.Fprintf(cw, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Fixed objects: 1 catalog, 2 page tree, 3-5 font. Pages start at 6,
+	// each followed by its content stream.
+	const firstPage = 6
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+i*2)
+	}
+
+	io.WriteString(cw, "%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	obj("<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>")
+	obj("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> " +
+		"/FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>")
+	obj("<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+
+	for i, page := range p.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, firstPage+i*2+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := cw.n
+	fmt.Fprintf(cw, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(cw, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(cw, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
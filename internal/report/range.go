@@ -0,0 +1,96 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Day truncates t to local midnight.
+func Day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// WeekStart returns the Monday of t's week.
+func WeekStart(t time.Time) time.Time {
+	d := Day(t)
+	offset := (int(d.Weekday()) + 6) % 7
+	return d.AddDate(0, 0, -offset)
+}
+
+// NamedRange resolves a rolling range name relative to now into a
+// half-open [start, end) interval:
+//
+//	today     the current day
+//	yesterday the previous day
+//	week      Monday of this week up to and including today
+//	lastweek  Monday to Sunday of the previous week
+//	month     the first of this month up to and including today
+//	lastmonth the whole previous month
+func NamedRange(name string, now time.Time) (time.Time, time.Time, bool) {
+	today := Day(now)
+	switch name {
+	case "today":
+		return today, today.AddDate(0, 0, 1), true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, true
+	case "week":
+		return WeekStart(now), today.AddDate(0, 0, 1), true
+	case "lastweek":
+		ws := WeekStart(now)
+		return ws.AddDate(0, 0, -7), ws, true
+	case "month":
+		return today.AddDate(0, 0, 1-today.Day()), today.AddDate(0, 0, 1), true
+	case "lastmonth":
+		first := today.AddDate(0, 0, 1-today.Day())
+		return first.AddDate(0, -1, 0), first, true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// TermStart returns the start of the term containing t and of the term
+// before it. starts lists the month-day each term begins on, e.g.
+// ["02-01", "09-01"]; malformed entries are ignored.
+func TermStart(t time.Time, starts []string) (time.Time, time.Time) {
+	var days []time.Time
+	for year := t.Year() - 2; year <= t.Year(); year++ {
+		for _, s := range starts {
+			d, err := time.ParseInLocation("2006-01-02", fmt.Sprintf("%d-%s", year, strings.TrimSpace(s)), t.Location())
+			if err == nil {
+				days = append(days, d)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	today := Day(t)
+	for i := len(days) - 1; i >= 1; i-- {
+		if !days[i].After(today) {
+			return days[i], days[i-1]
+		}
+	}
+	// No usable configuration: fall back to calendar half-years.
+	if t.Month() >= time.July {
+		cur := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, t.Location())
+		return cur, cur.AddDate(0, -6, 0)
+	}
+	cur := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	return cur, cur.AddDate(0, -6, 0)
+}
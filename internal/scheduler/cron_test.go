@@ -0,0 +1,88 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNext(t *testing.T) {
+	// 2025-03-05 is a Wednesday.
+	from := time.Date(2025, 3, 5, 10, 7, 30, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2025, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(3, 5, 10, 8)},
+		{"*/15 * * * *", at(3, 5, 10, 15)},
+		{"0 * * * *", at(3, 5, 11, 0)},
+		{"@hourly", at(3, 5, 11, 0)},
+		{"@daily", at(3, 6, 0, 0)},
+		{"@weekly", at(3, 10, 0, 0)},
+		{"@monthly", at(4, 1, 0, 0)},
+		{"30 22 * * *", at(3, 5, 22, 30)},
+		{"0 8-18/2 * * *", at(3, 5, 12, 0)},
+		{"0 9 * * 1,3", at(3, 10, 9, 0)},
+		{"0 9 * * 1-5", at(3, 6, 9, 0)},
+		{"0 0 * * 7", at(3, 9, 0, 0)},
+		{"0 0 * * 0", at(3, 9, 0, 0)},
+		{"0 0 1 * *", at(4, 1, 0, 0)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either may match.
+		{"0 0 10 * 5", at(3, 7, 0, 0)},
+		// A stepped * day field still counts as unrestricted, so both must match.
+		{"0 0 */2 * 1", at(3, 17, 0, 0)},
+		{"0 0 10 * */2", at(4, 10, 0, 0)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@yearly",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (C) 2025 Russell Li (xiaoxinmm)
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package scheduler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"suv/internal/model"
+	"suv/internal/report"
+)
+
+// Generator renders the report of job for [start, end) in the job's
+// format, applying its building and period filters.
+type Generator func(w io.Writer, job model.ReportJob, start, end time.Time) error
+
+var ErrRunning = errors.New("job is already running")
+
+// Scheduler runs the report jobs stored in report_jobs. Every job writes
+// into its own directory under dir (job-<id>) and keeps at most Retention
+// files there; each run is recorded in report_job_runs.
+type Scheduler struct {
+	db  *sql.DB
+	dir string
+	gen Generator
+
+	mu      sync.Mutex
+	running map[uint]bool
+}
+
+func New(db *sql.DB, dir string, gen Generator) *Scheduler {
+	return &Scheduler{db: db, dir: dir, gen: gen, running: map[uint]bool{}}
+}
+
+// Start launches the minute loop in the background.
+func (s *Scheduler) Start() {
+	// Runs left as "running" belong to a previous process that died.
+	if _, err := s.db.Exec(
+		"UPDATE report_job_runs SET status = 'failed', error = '服务重启，任务中断', finished_at = NOW() WHERE status = 'running'",
+	); err != nil {
+		log.Printf("Scheduler cleanup error: %v", err)
+	}
+
+	go func() {
+		for {
+			next := time.Now().Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(time.Until(next))
+			s.tick(next)
+		}
+	}()
+	log.Printf("Report scheduler started, output dir: %s", s.dir)
+}
+
+func (s *Scheduler) tick(t time.Time) {
+	jobs, err := s.Jobs(true)
+	if err != nil {
+		log.Printf("Scheduler load jobs error: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		sched, err := Parse(job.Cron)
+		if err != nil || !sched.Match(t) {
+			continue
+		}
+		go func(job model.ReportJob) {
+			if err := s.Run(job, t); err != nil && !errors.Is(err, ErrRunning) {
+				log.Printf("Report job %d (%s) failed: %v", job.ID, job.Name, err)
+			}
+		}(job)
+	}
+}
+
+// Jobs lists the configured jobs with their next fire time filled in.
+func (s *Scheduler) Jobs(enabledOnly bool) ([]model.ReportJob, error) {
+	q := "SELECT id, name, cron, format, range_name, building, period, retention, enabled, created_at FROM report_jobs"
+	if enabledOnly {
+		q += " WHERE enabled = 1"
+	}
+	rows, err := s.db.Query(q + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []model.ReportJob{}
+	now := time.Now()
+	for rows.Next() {
+		var j model.ReportJob
+		if err := rows.Scan(&j.ID, &j.Name, &j.Cron, &j.Format, &j.Range, &j.Building, &j.Period,
+			&j.Retention, &j.Enabled, &j.CreatedAt); err != nil {
+			return nil, err
+		}
+		if sched, err := Parse(j.Cron); err == nil && j.Enabled {
+			if next := sched.Next(now); !next.IsZero() {
+				j.NextRun = &next
+			}
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *Scheduler) Job(id uint) (model.ReportJob, error) {
+	var j model.ReportJob
+	err := s.db.QueryRow(
+		"SELECT id, name, cron, format, range_name, building, period, retention, enabled, created_at FROM report_jobs WHERE id = ?", id,
+	).Scan(&j.ID, &j.Name, &j.Cron, &j.Format, &j.Range, &j.Building, &j.Period, &j.Retention, &j.Enabled, &j.CreatedAt)
+	return j, err
+}
+
+// Run executes one job for the range as seen from now. It returns
+// ErrRunning if the previous run of the same job has not finished.
+func (s *Scheduler) Run(job model.ReportJob, now time.Time) error {
+	s.mu.Lock()
+	if s.running[job.ID] {
+		s.mu.Unlock()
+		return ErrRunning
+	}
+	s.running[job.ID] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.ID)
+		s.mu.Unlock()
+	}()
+
+	res, err := s.db.Exec("INSERT INTO report_job_runs (job_id, status, started_at) VALUES (?, 'running', ?)", job.ID, now)
+	if err != nil {
+		return err
+	}
+	runID, _ := res.LastInsertId()
+
+	path, size, err := s.generate(job, now)
+	if err != nil {
+		s.db.Exec("UPDATE report_job_runs SET status = 'failed', error = ?, finished_at = NOW() WHERE id = ?",
+			err.Error(), runID)
+		return err
+	}
+
+	s.db.Exec("UPDATE report_job_runs SET status = 'success', file_path = ?, file_size = ?, finished_at = NOW() WHERE id = ?",
+		path, size, runID)
+	s.prune(job)
+	return nil
+}
+
+func (s *Scheduler) generate(job model.ReportJob, now time.Time) (string, int64, error) {
+	start, end, ok := report.NamedRange(job.Range, now)
+	if !ok {
+		return "", 0, fmt.Errorf("unknown range %q", job.Range)
+	}
+
+	dir := s.jobDir(job.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+
+	// Write under a temporary name so a half-written file never shows up
+	// next to the finished ones.
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.gen(tmp, job, start, end); err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	name := fmt.Sprintf("%s_%s_%s_%s.%s", report.SafeFilename(job.Name),
+		start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"),
+		now.Format("20060102-1504"), job.Format)
+	path := filepath.Join(dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+// prune deletes the oldest files of a job beyond its retention count.
+func (s *Scheduler) prune(job model.ReportJob) {
+	if job.Retention <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(s.jobDir(job.ID))
+	if err != nil {
+		return
+	}
+
+	type file struct {
+		name string
+		mod  time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{e.Name(), info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+
+	for _, f := range files[min(job.Retention, len(files)):] {
+		if err := os.Remove(filepath.Join(s.jobDir(job.ID), f.name)); err != nil {
+			log.Printf("Report prune error: %v", err)
+		}
+	}
+}
+
+func (s *Scheduler) jobDir(id uint) string {
+	return filepath.Join(s.dir, fmt.Sprintf("job-%d", id))
+}
@@ -34,20 +34,50 @@ const App = {
       delete options.json;
     }
 
-    const res = await fetch(url, {
+    const send = () => fetch(url, {
       ...options,
       headers,
       credentials: 'same-origin'
     });
 
+    let res = await send();
+
+    // 访问令牌有效期很短，过期后用刷新令牌换一个新的再重试一次
+    if (res.status === 401 && url !== '/api/login' && await this.refresh()) {
+      res = await send();
+    }
+
     if (res.status === 401) {
-      window.location.href = '/login';
+      if (window.location.pathname !== '/login') {
+        window.location.href = '/login?next=' + encodeURIComponent(window.location.pathname + window.location.search);
+      }
       return null;
     }
 
+    // 初始密码未修改时，除改密外的接口都返回 403
+    if (res.status === 403 && window.location.pathname !== '/password') {
+      const body = await res.clone().json().catch(() => ({}));
+      if (body.must_change_password) {
+        window.location.href = '/password';
+        return null;
+      }
+    }
+
     return res;
   },
 
+  // 同时有多个请求过期时只刷新一次
+  refresh() {
+    if (!this._refreshing) {
+      this._refreshing = fetch('/api/refresh', { method: 'POST', credentials: 'same-origin' })
+        // 409 表示别的标签页刚刚刷新过，cookie 已经是新的
+        .then(res => res.ok || res.status === 409)
+        .catch(() => false)
+        .finally(() => { setTimeout(() => { this._refreshing = null; }, 0); });
+    }
+    return this._refreshing;
+  },
+
   async apiJSON(url, options = {}) {
     const res = await this.api(url, options);
     if (!res) return null;
@@ -119,6 +149,22 @@ const App = {
     window.location.href = '/';
   },
 
+  // 是否有某项权限（含只限本部门、本楼、所带班级、本人的）
+  can(perm) {
+    var perms = (this.user && this.user.permissions) || [];
+    return perms.indexOf('*') >= 0 || perms.indexOf(perm) >= 0 ||
+      perms.indexOf(perm + ':own-dept') >= 0 || perms.indexOf(perm + ':own-building') >= 0 ||
+      perms.indexOf(perm + ':own-class') >= 0 || perms.indexOf(perm + ':own') >= 0;
+  },
+
+  // 登录后的首页：能录入的去录入页，其余（管理员、班主任）去记录查询页
+  homePage(user) {
+    if (user) this.user = user;
+    var perms = (this.user && this.user.permissions) || [];
+    if (perms.indexOf('*') >= 0 || !this.can('violation:create')) return '/audit';
+    return '/record';
+  },
+
   async checkAuth() {
     var data = await this.apiJSON('/api/me');
     if (data && data.user) {